@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tracyhatemice/who/api"
+	"github.com/tracyhatemice/who/event"
+)
+
+// historySize is how many changes are kept per name.
+const historySize = 100
+
+// history keeps the recent changes of every name.
+type history struct {
+	mu     sync.Mutex
+	events map[string][]api.Event
+}
+
+func newHistory() *history {
+	return &history{events: make(map[string][]api.Event)}
+}
+
+// Handle implements event.Subscriber.
+func (h *history) Handle(e event.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events := append(h.events[e.Name], api.NewEvent(e))
+	if len(events) > historySize {
+		events = slices.Delete(events, 0, len(events)-historySize)
+	}
+	h.events[e.Name] = events
+}
+
+// Get returns the recent changes of name, oldest first.
+func (h *history) Get(name string) []api.Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.events[name])
+}
+
+// All returns the recent changes of every name.
+func (h *history) All() map[string][]api.Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	all := make(map[string][]api.Event, len(h.events))
+	for name, events := range h.events {
+		all[name] = slices.Clone(events)
+	}
+	return all
+}
+
+// Restore puts imported changes of name before the ones recorded since.
+// Changes already recorded, e.g. from importing the same dump twice, are
+// skipped. Timestamps are only precise to the second, so identical changes
+// are matched by count: a name flapping twice within a second was recorded
+// twice.
+func (h *history) Restore(name string, imported []api.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	type key struct {
+		timestamp  string
+		kind       event.Kind
+		ip         string
+		previousIP string
+		source     string
+	}
+	keyOf := func(e api.Event) key {
+		return key{e.Timestamp, e.Kind, e.IP, e.PreviousIP, e.Source}
+	}
+	recorded := make(map[key]int)
+	for _, e := range h.events[name] {
+		recorded[keyOf(e)]++
+	}
+	var events []api.Event
+	for _, e := range imported {
+		if k := keyOf(e); recorded[k] > 0 {
+			recorded[k]--
+		} else {
+			events = append(events, e)
+		}
+	}
+
+	events = append(events, h.events[name]...)
+	if len(events) > historySize {
+		events = slices.Delete(events, 0, len(events)-historySize)
+	}
+	h.events[name] = events
+}
+
+// withAdmin requires the admin token as a bearer token.
+func (s *Server) withAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return withToken(s.adminToken, next)
+}
+
+// withIAM requires the check-in token as a bearer token, if one is set.
+func (s *Server) withIAM(next http.HandlerFunc) http.HandlerFunc {
+	if s.iamToken == "" {
+		return next
+	}
+	return withToken(s.iamToken, next)
+}
+
+// withToken requires want as a bearer token.
+func withToken(want string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="who"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// nameInfo describes a name, alias, failover group or derived name.
+func (s *Server) nameInfo(name string) api.Name {
+	n := api.Name{Name: name, Type: api.TypeName, IPs: s.resolve(name)}
+	switch {
+	case s.aliases[name] != nil:
+		n.Type = api.TypeAlias
+	case s.failover.Has(name):
+		n.Type = api.TypeFailover
+	case s.isDerived(name):
+		n.Type = api.TypeDerived
+	}
+	if n.Type == api.TypeName || n.Type == api.TypeDerived {
+		_, n.Seen, _ = s.store.Seen(name)
+	}
+	if n.IPs == nil {
+		n.IPs = []string{}
+	}
+	return n
+}
+
+// adminNamesHandler lists every name.
+func (s *Server) adminNamesHandler(w http.ResponseWriter, _ *http.Request) {
+	names := []api.Name{}
+	for _, name := range s.names() {
+		names = append(names, s.nameInfo(name))
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
+// adminSetHandler sets the IP of a name as if it checked in.
+func (s *Server) adminSetHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if reason := s.readOnly(name); reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+
+	var req api.SetName
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	addr, err := netip.ParseAddr(req.IP)
+	if err != nil {
+		http.Error(w, "valid IP required", http.StatusBadRequest)
+		return
+	}
+
+	s.checkIn(name, addr.Unmap().String(), event.SourceAdmin)
+	writeJSON(w, http.StatusOK, s.nameInfo(name))
+}
+
+// adminDeleteHandler removes a stored name.
+func (s *Server) adminDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if reason := s.readOnly(name); reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+
+	ip, version := s.store.Delete(name)
+	if version == 0 {
+		http.Error(w, "name not found", http.StatusNotFound)
+		return
+	}
+	s.bus.Publish(event.Event{Kind: event.Delete, Name: name, Old: ip, Source: event.SourceAdmin, Seq: version})
+	if groups := s.failover.GroupsOf(name); len(groups) > 0 {
+		s.checkFailover(groups...)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminHistoryHandler returns the recent changes of a name.
+func (s *Server) adminHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	events := s.history.Get(r.PathValue("name"))
+	if events == nil {
+		events = []api.Event{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/tracyhatemice/who/api"
+	"github.com/tracyhatemice/who/event"
+)
+
+func TestHistoryRestore(t *testing.T) {
+	change := func(ts, ip, previous string) api.Event {
+		return api.Event{Kind: event.Change, IAM: "nas", IP: ip, PreviousIP: previous, Timestamp: ts}
+	}
+	// The name flaps twice within a second, then changes again
+	dump := []api.Event{
+		change("2026-01-01T00:00:00Z", "10.0.0.2", "10.0.0.1"),
+		change("2026-01-01T00:00:00Z", "10.0.0.1", "10.0.0.2"),
+		change("2026-01-01T00:00:00Z", "10.0.0.2", "10.0.0.1"),
+		change("2026-01-01T00:00:00Z", "10.0.0.1", "10.0.0.2"),
+		change("2026-01-01T00:00:05Z", "10.0.0.3", "10.0.0.1"),
+	}
+	h := newHistory()
+	h.Handle(event.Event{Kind: event.Change, Name: "nas", New: "10.0.0.4", Old: "10.0.0.3"})
+	recorded := h.Get("nas")
+
+	h.Restore("nas", dump)
+	h.Restore("nas", dump)
+	h.Restore("nas", dump[:2])
+
+	want := append(slices.Clone(dump), recorded...)
+	if got := h.Get("nas"); !slices.Equal(got, want) {
+		t.Errorf("history = %s, want %s", describeEvents(got), describeEvents(want))
+	}
+}
+
+func describeEvents(events []api.Event) []string {
+	var s []string
+	for _, e := range events {
+		s = append(s, fmt.Sprintf("%s %s>%s", e.Timestamp, e.PreviousIP, e.IP))
+	}
+	return s
+}
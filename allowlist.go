@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+)
+
+// widening is the prefix length that stored IPs are widened to when they
+// are matched against or exported as allowlists.
+type widening struct {
+	v4 int // 32 means no widening
+	v6 int // 128 means no widening
+}
+
+// parseWidening reads the optional v4_prefix and v6_prefix query parameters.
+func parseWidening(r *http.Request) (widening, error) {
+	w := widening{v4: 32, v6: 128}
+	for _, p := range []struct {
+		param string
+		bits  *int
+		max   int
+	}{
+		{"v4_prefix", &w.v4, 32},
+		{"v6_prefix", &w.v6, 128},
+	} {
+		value := r.URL.Query().Get(p.param)
+		if value == "" {
+			continue
+		}
+		bits, err := strconv.Atoi(value)
+		if err != nil || bits < 0 || bits > p.max {
+			return w, fmt.Errorf("%s must be between 0 and %d", p.param, p.max)
+		}
+		*p.bits = bits
+	}
+	return w, nil
+}
+
+// prefix returns the network containing ip, widened to the configured
+// prefix length for its address family.
+func (w widening) prefix(ip string) (netip.Prefix, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	bits := w.v6
+	if addr.Is4() {
+		bits = w.v4
+	}
+	prefix, err := addr.Prefix(bits)
+	return prefix, err == nil
+}
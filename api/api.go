@@ -0,0 +1,86 @@
+// Package api defines the JSON types of the HTTP API and a client for the
+// admin endpoints, shared by the server and the command line tools.
+package api
+
+import (
+	"time"
+
+	"github.com/tracyhatemice/who/event"
+)
+
+// Name types.
+const (
+	TypeName     = "name"
+	TypeAlias    = "alias"
+	TypeFailover = "failover"
+	TypeDerived  = "derived"
+)
+
+// Name is a name and its current addresses, as listed by GET /admin/names.
+type Name struct {
+	Name string    `json:"name"`
+	Type string    `json:"type"`
+	IPs  []string  `json:"ips"`
+	Seen time.Time `json:"seen,omitzero"` // last check-in of stored names
+}
+
+// SetName is the body of PUT /admin/names/{name}.
+type SetName struct {
+	IP string `json:"ip"`
+}
+
+// Event is a change as sent to /events clients and returned by
+// GET /admin/history/{name}.
+type Event struct {
+	ID         uint64     `json:"id,omitempty"` // stream position, only set on /events
+	Kind       event.Kind `json:"event"`
+	IAM        string     `json:"iam"`
+	IP         string     `json:"ip"`
+	PreviousIP string     `json:"previous_ip"`
+	Source     string     `json:"source,omitempty"`
+	Timestamp  string     `json:"timestamp"`
+}
+
+// NewEvent converts a bus event.
+func NewEvent(e event.Event) Event {
+	return Event{
+		Kind:       e.Kind,
+		IAM:        e.Name,
+		IP:         e.New,
+		PreviousIP: e.Old,
+		Source:     e.Source,
+		Timestamp:  e.Time.UTC().Format(time.RFC3339),
+	}
+}
+
+// DumpVersion is the version of the Dump format written by this version.
+const DumpVersion = 1
+
+// Dump is the full state of a server, as returned by GET /admin/export and
+// accepted by POST /admin/import.
+type Dump struct {
+	Version  int                `json:"version"`
+	Exported time.Time          `json:"exported"`
+	Names    []DumpName         `json:"names"`
+	History  map[string][]Event `json:"history,omitempty"`
+}
+
+// DumpName is a stored name in a Dump.
+type DumpName struct {
+	Name string    `json:"name"`
+	IP   string    `json:"ip"`
+	Seen time.Time `json:"seen"`
+}
+
+// Import modes.
+const (
+	ImportReplay = "replay" // notify DDNS, webhooks and other subscribers of changed names
+	ImportSilent = "silent" // only restore the store
+)
+
+// ImportResult is the response of POST /admin/import.
+type ImportResult struct {
+	Imported int      `json:"imported"`
+	Changed  int      `json:"changed"`
+	Skipped  []string `json:"skipped,omitempty"` // aliases, failover groups and derived names on this server
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the admin API of a who server.
+type Client struct {
+	Server string // base URL, e.g. https://who.example.org
+	Token  string // admin token, sent as a bearer token
+	HTTP   *http.Client
+}
+
+// Names returns every name, alias, failover group and derived name.
+func (c *Client) Names(ctx context.Context) ([]Name, error) {
+	var names []Name
+	err := c.do(ctx, http.MethodGet, "/admin/names", nil, &names)
+	return names, err
+}
+
+// SetName sets the address of a name, like a check-in via /iam.
+func (c *Client) SetName(ctx context.Context, name, ip string) (Name, error) {
+	var n Name
+	err := c.do(ctx, http.MethodPut, "/admin/names/"+url.PathEscape(name), SetName{IP: ip}, &n)
+	return n, err
+}
+
+// DeleteName removes a name.
+func (c *Client) DeleteName(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/admin/names/"+url.PathEscape(name), nil, nil)
+}
+
+// History returns the recent changes of a name, oldest first.
+func (c *Client) History(ctx context.Context, name string) ([]Event, error) {
+	var events []Event
+	err := c.do(ctx, http.MethodGet, "/admin/history/"+url.PathEscape(name), nil, &events)
+	return events, err
+}
+
+// Export returns the full state of the server.
+func (c *Client) Export(ctx context.Context) (Dump, error) {
+	var dump Dump
+	err := c.do(ctx, http.MethodGet, "/admin/export", nil, &dump)
+	return dump, err
+}
+
+// Import restores a dump in the given mode, ImportReplay or ImportSilent.
+func (c *Client) Import(ctx context.Context, dump Dump, mode string) (ImportResult, error) {
+	var result ImportResult
+	err := c.do(ctx, http.MethodPost, "/admin/import?mode="+url.QueryEscape(mode), dump, &result)
+	return result, err
+}
+
+// Events calls fn for every event streamed by /events, or /events/{name}
+// if name is set, starting after lastID. It returns when ctx is cancelled,
+// the stream ends or fn returns an error. The ID of the last event seen is
+// returned so the stream can be resumed.
+func (c *Client) Events(ctx context.Context, name string, lastID uint64, fn func(Event) error) (uint64, error) {
+	path := "/events"
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	req, err := c.request(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return lastID, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+	}
+
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return lastID, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return lastID, err
+	}
+
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue // id, event, retry and comment lines
+		}
+
+		var e Event
+		err := json.Unmarshal([]byte(data.String()), &e)
+		data.Reset()
+		if err != nil {
+			return lastID, fmt.Errorf("invalid event: %w", err)
+		}
+		lastID = max(lastID, e.ID)
+		if err := fn(e); err != nil {
+			return lastID, err
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return lastID, err
+	}
+	return lastID, ctx.Err()
+}
+
+// do sends body as JSON and decodes the JSON response into out, if set.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := c.request(ctx, method, path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) request(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.Server, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return req, nil
+}
+
+func (c *Client) client() *http.Client {
+	if c.HTTP == nil {
+		return http.DefaultClient
+	}
+	return c.HTTP
+}
+
+// checkStatus turns a non-2xx response into an error with the response body.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
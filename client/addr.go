@@ -0,0 +1,76 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// cgnat is the shared address space of carrier-grade NAT (RFC 6598), which
+// isn't reachable from the internet.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// Address scopes that can be selected from an interface.
+const (
+	ScopeGlobal    = "global"
+	ScopePrivate   = "private" // IPv6 ULA (fc00::/7), IPv4 RFC 1918 or CGNAT (100.64.0.0/10)
+	ScopeLinkLocal = "link-local"
+)
+
+// Scope returns the scope of addr, or "" for loopback, multicast and
+// unspecified addresses, which are never reported.
+func Scope(addr netip.Addr) string {
+	switch {
+	case addr.IsLoopback(), addr.IsMulticast(), addr.IsUnspecified():
+		return ""
+	case addr.IsLinkLocalUnicast():
+		return ScopeLinkLocal
+	case addr.IsPrivate(), cgnat.Contains(addr):
+		return ScopePrivate
+	default:
+		return ScopeGlobal
+	}
+}
+
+// InterfaceAddr returns the first address of the named interface that
+// belongs to family ("ipv4", "ipv6" or "" for either) and one of scopes.
+// Temporary IPv6 privacy addresses are skipped where the platform can tell
+// them apart.
+func InterfaceAddr(name, family string, scopes []string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	skip, err := unstableAddrs(name)
+	if err != nil {
+		return "", err
+	}
+
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		addr := prefix.Addr().Unmap()
+		switch {
+		case family == "ipv4" && !addr.Is4(), family == "ipv6" && !addr.Is6():
+		case !slices.Contains(scopes, Scope(addr)):
+		case skip[addr]:
+		default:
+			return addr.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no %s address with scope %v on %s", familyName(family), scopes, name)
+}
+
+func familyName(family string) string {
+	if family == "" {
+		return "IP"
+	}
+	return family
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// IPv6 address flags from linux/if_addr.h.
+const (
+	ifaFlagTemporary  = 0x01
+	ifaFlagDADFailed  = 0x08
+	ifaFlagDeprecated = 0x20
+	ifaFlagTentative  = 0x40
+)
+
+// unstableAddrs returns the IPv6 addresses of an interface that shouldn't
+// be published: temporary privacy addresses, which rotate, and deprecated,
+// tentative or duplicate ones.
+func unstableAddrs(name string) (map[netip.Addr]bool, error) {
+	f, err := os.Open("/proc/net/if_inet6")
+	if os.IsNotExist(err) {
+		return nil, nil // IPv6 disabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseUnstableAddrs(f, name)
+}
+
+// parseUnstableAddrs reads the unstable addresses of an interface from
+// /proc/net/if_inet6.
+func parseUnstableAddrs(r io.Reader, name string) (map[netip.Addr]bool, error) {
+	// Each line is: address ifindex prefixlen scope flags name, e.g.
+	// 20010db8000000000000000000000001 02 40 00 01 eth0
+	unstable := make(map[netip.Addr]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 || fields[5] != name {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil || len(raw) != 16 {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		if flags&(ifaFlagTemporary|ifaFlagDADFailed|ifaFlagDeprecated|ifaFlagTentative) != 0 {
+			unstable[netip.AddrFrom16([16]byte(raw))] = true
+		}
+	}
+	return unstable, scanner.Err()
+}
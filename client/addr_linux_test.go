@@ -0,0 +1,48 @@
+package client
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseUnstableAddrs(t *testing.T) {
+	const ifInet6 = `20010db8000000000000000000000001 02 40 00 00     eth0
+20010db8000000000000000000000002 02 40 00 01     eth0
+20010db8000000000000000000000003 02 40 00 08     eth0
+20010db8000000000000000000000004 02 40 00 20     eth0
+20010db8000000000000000000000005 02 40 00 40     eth0
+20010db8000000000000000000000006 02 40 00 80     eth0
+20010db8000000000000000000000007 02 40 00 221    eth0
+fe800000000000000000000000000001 02 40 20 80     eth0
+20010db8000000000000000000000008 03 40 00 01     wlan0
+00000000000000000000000000000001 01 80 10 80     lo
+not-an-address 02 40 00 01 eth0
+20010db8000000000000000000000009 02 40 00 zz     eth0
+`
+	tests := []struct {
+		iface string
+		want  []string
+	}{
+		{"eth0", []string{"2001:db8::2", "2001:db8::3", "2001:db8::4", "2001:db8::5", "2001:db8::7"}},
+		{"wlan0", []string{"2001:db8::8"}},
+		{"lo", nil},
+	}
+	for _, tt := range tests {
+		unstable, err := parseUnstableAddrs(strings.NewReader(ifInet6), tt.iface)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.iface, err)
+		}
+		var got []string
+		for addr := range unstable {
+			got = append(got, addr.String())
+		}
+		slices.SortFunc(got, func(a, b string) int {
+			return netip.MustParseAddr(a).Compare(netip.MustParseAddr(b))
+		})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("unstable addresses of %s = %q, want %q", tt.iface, got, tt.want)
+		}
+	}
+}
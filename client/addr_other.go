@@ -0,0 +1,11 @@
+//go:build !linux
+
+package client
+
+import "net/netip"
+
+// unstableAddrs can't tell temporary addresses apart on this platform, so
+// every address is a candidate.
+func unstableAddrs(string) (map[netip.Addr]bool, error) {
+	return nil, nil
+}
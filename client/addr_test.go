@@ -0,0 +1,37 @@
+package client
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"203.0.113.10", ScopeGlobal},
+		{"2001:db8::1", ScopeGlobal},
+		{"192.168.1.10", ScopePrivate},
+		{"10.0.0.5", ScopePrivate},
+		{"172.16.0.1", ScopePrivate},
+		{"fd12:3456::1", ScopePrivate},
+		{"100.64.0.1", ScopePrivate},
+		{"100.127.255.254", ScopePrivate},
+		{"100.63.255.255", ScopeGlobal},
+		{"100.128.0.1", ScopeGlobal},
+		{"169.254.1.1", ScopeLinkLocal},
+		{"fe80::1", ScopeLinkLocal},
+		{"127.0.0.1", ""},
+		{"::1", ""},
+		{"224.0.0.1", ""},
+		{"ff02::1", ""},
+		{"0.0.0.0", ""},
+		{"::", ""},
+	}
+	for _, tt := range tests {
+		if got := Scope(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Scope(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modes of finding out the current address.
+const (
+	// ModeWhoami asks /whoami for the address and only calls
+	// /iam/{name}/{ip} when it changed.
+	ModeWhoami = "whoami"
+	// ModeIAM asks /whoami for the address too, but reports it by calling
+	// /iam/{name}, letting the server record the address it sees.
+	ModeIAM = "iam"
+)
+
+const (
+	requestTimeout = 30 * time.Second
+	minBackoff     = 5 * time.Second
+	maxBackoff     = 10 * time.Minute
+)
+
+// Reporter keeps one name up to date on a who server.
+type Reporter struct {
+	Name     string
+	Server   string // base URL, e.g. https://who.example.org
+	Family   string // "ipv4", "ipv6" or "" for whichever the connection uses
+	Token    string // sent as a bearer token if set
+	Mode     string
+	Interval time.Duration
+	Refresh  time.Duration // report an unchanged address at least this often, 0 disables
+	Client   *http.Client  // defaults to a client dialing only Family
+
+	// Interface, if set, takes the address from this local interface instead
+	// of asking /whoami, e.g. to publish a global IPv6 address from behind a
+	// firewall. Scopes selects which addresses qualify; empty means global.
+	Interface string
+	Scopes    []string
+
+	last       string
+	lastReport time.Time
+}
+
+// Run runs every reporter until ctx is cancelled.
+func Run(ctx context.Context, reporters []*Reporter) {
+	var wg sync.WaitGroup
+	for _, r := range reporters {
+		wg.Go(func() { r.Run(ctx) })
+	}
+	wg.Wait()
+}
+
+// Run checks and reports the address every Interval, backing off
+// exponentially after failures, until ctx is cancelled.
+func (r *Reporter) Run(ctx context.Context) {
+	backoff := minBackoff
+	for {
+		wait := r.Interval
+		if err := r.Check(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			wait = backoff
+			backoff = min(backoff*2, maxBackoff)
+			log.Printf("CLIENT: %s: %v, retrying in %s", r.Name, err, wait)
+		} else {
+			backoff = minBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Check finds out the current address and reports it if it changed, or if
+// Refresh has elapsed since the last report.
+func (r *Reporter) Check(ctx context.Context) error {
+	ip, err := r.current(ctx)
+	if err != nil {
+		return err
+	}
+	if ip == r.last && (r.Refresh <= 0 || time.Since(r.lastReport) < r.Refresh) {
+		return nil
+	}
+	path := "/iam/" + url.PathEscape(r.Name)
+	if r.Mode != ModeIAM {
+		path += "/" + ip
+	}
+	reported, err := r.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	r.reported(reported)
+	return nil
+}
+
+// current returns the address to report, from the local interface or from
+// /whoami.
+func (r *Reporter) current(ctx context.Context) (string, error) {
+	if r.Interface == "" {
+		return r.get(ctx, "/whoami")
+	}
+	scopes := r.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{ScopeGlobal}
+	}
+	return InterfaceAddr(r.Interface, r.Family, scopes)
+}
+
+func (r *Reporter) reported(ip string) {
+	if ip != r.last {
+		log.Printf("CLIENT: %s -> %s", r.Name, ip)
+	}
+	r.last, r.lastReport = ip, time.Now()
+}
+
+// get requests path on the server and returns the IP in the response,
+// checking that it belongs to the reporter's address family.
+func (r *Reporter) get(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(r.Server, "/")+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return "", fmt.Errorf("invalid address in response: %q", body)
+	}
+	addr = addr.Unmap()
+	if (r.Family == "ipv4" && !addr.Is4()) || (r.Family == "ipv6" && !addr.Is6()) {
+		return "", errors.New("server saw " + addr.String() + ", not an " + r.Family + " address")
+	}
+	return addr.String(), nil
+}
+
+func (r *Reporter) client() *http.Client {
+	if r.Client == nil {
+		family := r.Family
+		if r.Interface != "" {
+			family = "" // the server needn't be reachable over the reported family
+		}
+		r.Client = NewHTTPClient(family)
+	}
+	return r.Client
+}
+
+// NewHTTPClient returns a client that only connects over the given address
+// family ("ipv4" or "ipv6"), or over either if family is empty.
+func NewHTTPClient(family string) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	network := map[string]string{"ipv4": "tcp4", "ipv6": "tcp6"}[family]
+	if network != "" {
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tracyhatemice/who/api"
+)
+
+// adminCommand holds the flags shared by the admin subcommands.
+type adminCommand struct {
+	fs     *flag.FlagSet
+	client api.Client
+	json   bool
+}
+
+func newAdminCommand(name, usage string) *adminCommand {
+	c := &adminCommand{fs: flag.NewFlagSet(name, flag.ExitOnError)}
+	c.fs.StringVar(&c.client.Server, "server", "", "URL of the who server (default: $WHO_SERVER or http://localhost)")
+	c.fs.StringVar(&c.client.Token, "token", "", "Admin token (default: $WHO_ADMIN_TOKEN)")
+	c.fs.BoolVar(&c.json, "json", false, "Print JSON instead of a table")
+	c.fs.Usage = func() {
+		fmt.Fprintf(c.fs.Output(), "Usage: who %s\n", usage)
+		c.fs.PrintDefaults()
+	}
+	return c
+}
+
+// parse parses args, applies the environment defaults and returns exactly n
+// positional arguments.
+func (c *adminCommand) parse(args []string, n int) []string {
+	c.parseFlags(args)
+	if c.fs.NArg() != n {
+		c.fs.Usage()
+		os.Exit(2)
+	}
+	return c.fs.Args()
+}
+
+// parseFlags parses args and applies the environment defaults. The token
+// isn't a flag default, so usage doesn't print it.
+func (c *adminCommand) parseFlags(args []string) {
+	_ = c.fs.Parse(args)
+	c.client.Server = cmp.Or(c.client.Server, os.Getenv("WHO_SERVER"), "http://localhost")
+	c.client.Token = cmp.Or(c.client.Token, os.Getenv("WHO_ADMIN_TOKEN"))
+}
+
+// print writes v as JSON, or calls table with a tab-separated writer.
+func (c *adminCommand) print(v any, table func(w *tabwriter.Writer)) {
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(w)
+	_ = w.Flush()
+}
+
+// namesMain runs the names subcommand: ls, set and rm.
+func namesMain(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("Usage: who names ls|set|rm")
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "ls":
+		c := newAdminCommand("names ls", "names ls [flags]")
+		c.parse(args[1:], 0)
+		names, err := c.client.Names(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.print(names, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, "NAME\tTYPE\tIP\tSEEN")
+			for _, n := range names {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", n.Name, n.Type, strings.Join(n.IPs, ","), formatTime(n.Seen))
+			}
+		})
+
+	case "set":
+		c := newAdminCommand("names set", "names set [flags] <name> <ip>")
+		args := c.parse(args[1:], 2)
+		n, err := c.client.SetName(ctx, args[0], args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.print(n, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "%s\t%s\n", n.Name, strings.Join(n.IPs, ","))
+		})
+
+	case "rm":
+		c := newAdminCommand("names rm", "names rm [flags] <name>")
+		args := c.parse(args[1:], 1)
+		if err := c.client.DeleteName(ctx, args[0]); err != nil {
+			log.Fatal(err)
+		}
+
+	default:
+		log.Fatalf("Unknown names command %q, expected ls, set or rm", args[0])
+	}
+}
+
+// historyMain runs the history subcommand, which prints the recent changes
+// of a name.
+func historyMain(args []string) {
+	c := newAdminCommand("history", "history [flags] <name>")
+	name := c.parse(args, 1)[0]
+	events, err := c.client.History(context.Background(), name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.print(events, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "TIME\tEVENT\tIP\tPREVIOUS\tSOURCE")
+		for _, e := range events {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Timestamp, e.Kind, cmp.Or(e.IP, "-"), cmp.Or(e.PreviousIP, "-"), e.Source)
+		}
+	})
+}
+
+// eventsMain runs the events subcommand. events tail follows the event
+// stream of every name, or of one name, reconnecting when it drops.
+func eventsMain(args []string) {
+	if len(args) == 0 || args[0] != "tail" {
+		log.Fatalf("Usage: who events tail [flags] [name]")
+	}
+	c := newAdminCommand("events tail", "events tail [flags] [name]")
+	c.parseFlags(args[1:])
+	if c.fs.NArg() > 1 {
+		c.fs.Usage()
+		os.Exit(2)
+	}
+	name := c.fs.Arg(0)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	enc := json.NewEncoder(os.Stdout)
+	show := func(e api.Event) error {
+		if c.json {
+			return enc.Encode(e)
+		}
+		_, err := fmt.Printf("%s  %-8s %s %s -> %s\n", e.Timestamp, e.Kind, e.IAM, cmp.Or(e.PreviousIP, "-"), cmp.Or(e.IP, "-"))
+		return err
+	}
+
+	var lastID uint64
+	for {
+		var err error
+		lastID, err = c.client.Events(ctx, name, lastID, show)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Event stream failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventRetryInterval):
+		}
+	}
+}
+
+// formatTime formats t for tables, or "-" if it's zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+// exportMain runs the export subcommand, which writes the full state of the
+// server as JSON.
+func exportMain(args []string) {
+	c := newAdminCommand("export", "export [flags]")
+	var output string
+	c.fs.StringVar(&output, "o", "", "Write to this file instead of stdout")
+	c.parse(args, 0)
+
+	dump, err := c.client.Export(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	data = append(data, '\n')
+	if output == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(output, data, 0o600)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// importMain runs the import subcommand, which restores a dump written by
+// export from a file or stdin.
+func importMain(args []string) {
+	c := newAdminCommand("import", "import [flags] [file]")
+	var mode string
+	c.fs.StringVar(&mode, "mode", api.ImportSilent, "replay: notify DDNS, webhooks and other subscribers of changed names; silent: only restore the names")
+	c.parseFlags(args)
+	if c.fs.NArg() > 1 {
+		c.fs.Usage()
+		os.Exit(2)
+	}
+
+	in := os.Stdin
+	if path := c.fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	var dump api.Dump
+	if err := json.NewDecoder(in).Decode(&dump); err != nil {
+		log.Fatalf("Invalid dump: %v", err)
+	}
+
+	result, err := c.client.Import(context.Background(), dump, mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.print(result, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "Imported %d names, %d changed\n", result.Imported, result.Changed)
+		if len(result.Skipped) > 0 {
+			fmt.Fprintf(w, "Skipped %s\n", strings.Join(result.Skipped, ", "))
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tracyhatemice/who/client"
+)
+
+// clientMain runs the client subcommand, which keeps names up to date on a
+// who server.
+func clientMain(args []string) {
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	var (
+		server, server4, server6 string
+		name, name4, name6       string
+		token, mode              string
+		iface, scope             string
+		interval, refresh        time.Duration
+		once                     bool
+	)
+	fs.StringVar(&server, "server", "", "URL of the who server, e.g. https://who.example.org")
+	fs.StringVar(&server4, "server4", "", "URL of the who server for -name4 (default: -server)")
+	fs.StringVar(&server6, "server6", "", "URL of the who server for -name6 (default: -server)")
+	fs.StringVar(&name, "name", "", "Name to report the address of whichever family the connection uses")
+	fs.StringVar(&name4, "name4", "", "Name to report the IPv4 address as")
+	fs.StringVar(&name6, "name6", "", "Name to report the IPv6 address as")
+	fs.StringVar(&token, "token", os.Getenv("WHO_TOKEN"), "Bearer token sent with every request, e.g. the server's check-in token (default: $WHO_TOKEN)")
+	fs.StringVar(&mode, "mode", client.ModeWhoami, "whoami: report changes seen by /whoami as /iam/{name}/{ip}; iam: report them as /iam/{name}")
+	fs.StringVar(&iface, "interface", "", "Take the address from this local interface instead of asking /whoami")
+	fs.StringVar(&scope, "scope", client.ScopeGlobal, "Comma-separated scopes of interface addresses to report: global, private, link-local")
+	fs.DurationVar(&interval, "interval", 5*time.Minute, "How often to check the address")
+	fs.DurationVar(&refresh, "refresh", 0, "Report an unchanged address at least this often, e.g. to stay within the server's -expire (0 disables)")
+	fs.BoolVar(&once, "once", false, "Check once and exit")
+	_ = fs.Parse(args)
+
+	if mode != client.ModeWhoami && mode != client.ModeIAM {
+		log.Fatalf("Unknown mode %q", mode)
+	}
+	scopes := strings.Split(scope, ",")
+	for _, s := range scopes {
+		if s != client.ScopeGlobal && s != client.ScopePrivate && s != client.ScopeLinkLocal {
+			log.Fatalf("Unknown scope %q", s)
+		}
+	}
+	if iface != "" && mode == client.ModeIAM {
+		log.Fatalf("-interface can't be used with -mode iam")
+	}
+	if interval <= 0 {
+		log.Fatalf("Interval must be positive")
+	}
+
+	var reporters []*client.Reporter
+	for _, r := range []struct{ name, server, family string }{
+		{name, server, ""},
+		{name4, cmp.Or(server4, server), "ipv4"},
+		{name6, cmp.Or(server6, server), "ipv6"},
+	} {
+		if r.name == "" {
+			continue
+		}
+		if r.server == "" {
+			log.Fatalf("No server URL for %s", r.name)
+		}
+		reporters = append(reporters, &client.Reporter{
+			Name:     r.name,
+			Server:   r.server,
+			Family:   r.family,
+			Token:    token,
+			Mode:     mode,
+			Interval: interval,
+			Refresh:  refresh,
+
+			Interface: iface,
+			Scopes:    scopes,
+		})
+	}
+	if len(reporters) == 0 {
+		log.Fatalf("At least one of -name, -name4 or -name6 is required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if once {
+		failed := false
+		for _, r := range reporters {
+			if err := r.Check(ctx); err != nil {
+				log.Printf("CLIENT: %s: %v", r.Name, err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	log.Printf("CLIENT: reporting %d names every %s", len(reporters), interval)
+	client.Run(ctx, reporters)
+}
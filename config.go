@@ -2,41 +2,164 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 )
 
 // Config holds all application configuration.
 type Config struct {
-	Who      []WhoEntry      `json:"who"`
-	DDNS     []DDNSEntry     `json:"ddns"`
-	Webhooks []WebhookEntry  `json:"webhooks"`
+	Who      []WhoEntry     `json:"who"`
+	DDNS     []DDNSEntry    `json:"ddns"`
+	Webhooks []WebhookEntry `json:"webhooks"`
+	Sinks    []SinkEntry    `json:"sinks,omitempty"`
+	Exec     []ExecEntry    `json:"exec,omitempty"`
+	MQTT     *MQTTEntry     `json:"mqtt,omitempty"`
+	CheckIn  *CheckInEntry  `json:"check_in,omitempty"`
+	Admin    *AdminEntry    `json:"admin,omitempty"`
+	Zone     *ZoneEntry     `json:"zone,omitempty"`
 }
 
-// WhoEntry represents a pre-loaded name-to-IP mapping or alias.
+// WhoEntry represents a pre-loaded name-to-IP mapping, alias, failover group
+// or name derived from another name's IPv6 prefix.
 type WhoEntry struct {
-	IAM   string   `json:"iam"`
-	IP    string   `json:"ip,omitempty"`
-	Alias []string `json:"alias,omitempty"`
+	IAM          string   `json:"iam"`
+	IP           string   `json:"ip,omitempty"`
+	Alias        []string `json:"alias,omitempty"`
+	Failover     []string `json:"failover,omitempty"`
+	StaleAfter   Duration `json:"stale_after,omitempty"`
+	PrefixFrom   string   `json:"prefix_from,omitempty"`
+	IPv6Suffix   string   `json:"ipv6_suffix,omitempty"`
+	PrefixLength int      `json:"prefix_length,omitempty"`
 }
 
 // DDNSEntry represents a single DDNS configuration.
 type DDNSEntry struct {
-	Provider  string `json:"provider"`
-	Domain    string `json:"domain"`
-	IPVersion string `json:"ip_version"`
-	IAM       string `json:"iam"`
-	AccessKey string `json:"access_key"`
-	SecretKey string `json:"secret_key"`
-	ZoneID    string `json:"zone_id"`
-	TTL       int    `json:"ttl"`
+	Provider      string        `json:"provider"`
+	Domain        string        `json:"domain"`
+	IPVersion     string        `json:"ip_version"`
+	IAM           string        `json:"iam"`
+	Mode          string        `json:"mode,omitempty"`
+	Targets       []CNAMETarget `json:"targets,omitempty"`
+	StaleAfter    Duration      `json:"stale_after,omitempty"`
+	TXT           bool          `json:"txt,omitempty"`
+	AccessKey     string        `json:"access_key,omitempty"`
+	AccessKeyFile string        `json:"access_key_file,omitempty"`
+	SecretKey     string        `json:"secret_key,omitempty"`
+	SecretKeyFile string        `json:"secret_key_file,omitempty"`
+	ZoneID        string        `json:"zone_id"`
+	TTL           int           `json:"ttl"`
+	SyncTimeout   Duration      `json:"sync_timeout,omitempty"`
+	Debounce      Duration      `json:"debounce,omitempty"`
+	MinInterval   Duration      `json:"min_interval,omitempty"`
+	BatchWindow   Duration      `json:"batch_window,omitempty"`
+	IPv6Suffix    string        `json:"ipv6_suffix,omitempty"`
+	PrefixLength  int           `json:"prefix_length,omitempty"`
+
+	// Resolved credentials, never written back to the config file.
+	accessKey string
+	secretKey string
+}
+
+// CNAMETarget is a destination for a DDNS entry in cname mode.
+type CNAMETarget struct {
+	IAM    string `json:"iam"`
+	Domain string `json:"domain"`
 }
 
 // WebhookEntry represents a webhook notification configuration.
 type WebhookEntry struct {
-	IAM     string            `json:"iam"`
-	URL     string            `json:"url"`
-	Method  string            `json:"method"`
-	Headers map[string]string `json:"headers"`
+	IAM         string            `json:"iam"`
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	Headers     map[string]string `json:"headers"`
+	HeaderFiles map[string]string `json:"header_files,omitempty"`
+
+	// Resolved headers, never written back to the config file.
+	headers map[string]string
+}
+
+// SinkEntry represents a local file rendered from a template on change.
+type SinkEntry struct {
+	Path         string   `json:"path"`
+	Template     string   `json:"template,omitempty"`
+	TemplateFile string   `json:"template_file,omitempty"`
+	IAM          []string `json:"iam,omitempty"`
+	Command      []string `json:"command,omitempty"`
+}
+
+// ExecEntry represents a command run when a name's IP changes.
+type ExecEntry struct {
+	IAM           string   `json:"iam"`
+	Command       []string `json:"command"`
+	Timeout       Duration `json:"timeout,omitempty"`
+	MaxConcurrent int      `json:"max_concurrent,omitempty"`
+}
+
+// MQTTEntry represents the MQTT broker that changes are published to.
+type MQTTEntry struct {
+	URL             string   `json:"url"`
+	Username        string   `json:"username,omitempty"`
+	Password        string   `json:"password,omitempty"`
+	PasswordFile    string   `json:"password_file,omitempty"`
+	ClientID        string   `json:"client_id,omitempty"`
+	TopicPrefix     string   `json:"topic_prefix,omitempty"`
+	Discovery       bool     `json:"discovery,omitempty"`
+	DiscoveryPrefix string   `json:"discovery_prefix,omitempty"`
+	KeepAlive       Duration `json:"keep_alive,omitempty"`
+
+	// Resolved password, never written back to the config file.
+	password string
+}
+
+// CheckInEntry configures check-ins via /iam.
+type CheckInEntry struct {
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+
+	// Resolved token, never written back to the config file.
+	token string
+}
+
+// AdminEntry configures the admin API.
+type AdminEntry struct {
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+
+	// Resolved token, never written back to the config file.
+	token string
+}
+
+// ZoneEntry is the SOA serial of the exported zone and a hash of the
+// records it was last bumped for. It is maintained by the server.
+type ZoneEntry struct {
+	Serial int64  `json:"serial"`
+	Hash   string `json:"hash"`
+}
+
+// Duration is a time.Duration that is written to JSON as a string like "30s".
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
 }
 
 // LoadConfig reads configuration from a JSON file.
@@ -58,10 +181,14 @@ func LoadConfig(path string) (*Config, error) {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
 // SaveConfig writes configuration back to a JSON file.
+// Only the raw values from the file are written; resolved secrets are not.
 func SaveConfig(path string, cfg *Config) error {
 	data, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
@@ -69,3 +196,97 @@ func SaveConfig(path string, cfg *Config) error {
 	}
 	return os.WriteFile(path, append(data, '\n'), 0o600)
 }
+
+// resolveSecrets expands environment references and reads secret files
+// for every credential field.
+func (cfg *Config) resolveSecrets() error {
+	for i := range cfg.DDNS {
+		entry := &cfg.DDNS[i]
+		var err error
+		if entry.accessKey, err = resolveSecret(entry.AccessKey, entry.AccessKeyFile); err != nil {
+			return fmt.Errorf("ddns %s: access_key: %w", entry.Domain, err)
+		}
+		if entry.secretKey, err = resolveSecret(entry.SecretKey, entry.SecretKeyFile); err != nil {
+			return fmt.Errorf("ddns %s: secret_key: %w", entry.Domain, err)
+		}
+	}
+
+	if cfg.MQTT != nil {
+		var err error
+		if cfg.MQTT.password, err = resolveSecret(cfg.MQTT.Password, cfg.MQTT.PasswordFile); err != nil {
+			return fmt.Errorf("mqtt: password: %w", err)
+		}
+	}
+
+	if cfg.CheckIn != nil {
+		var err error
+		if cfg.CheckIn.token, err = resolveSecret(cfg.CheckIn.Token, cfg.CheckIn.TokenFile); err != nil {
+			return fmt.Errorf("check_in: token: %w", err)
+		}
+	}
+
+	if cfg.Admin != nil {
+		var err error
+		if cfg.Admin.token, err = resolveSecret(cfg.Admin.Token, cfg.Admin.TokenFile); err != nil {
+			return fmt.Errorf("admin: token: %w", err)
+		}
+	}
+
+	for i := range cfg.Webhooks {
+		entry := &cfg.Webhooks[i]
+		if len(entry.Headers) == 0 && len(entry.HeaderFiles) == 0 {
+			continue
+		}
+		entry.headers = make(map[string]string, len(entry.Headers)+len(entry.HeaderFiles))
+		for k, v := range entry.Headers {
+			value, err := resolveSecret(v, entry.HeaderFiles[k])
+			if err != nil {
+				return fmt.Errorf("webhook %s: header %s: %w", entry.URL, k, err)
+			}
+			entry.headers[k] = value
+		}
+		for k, file := range entry.HeaderFiles {
+			if _, ok := entry.Headers[k]; ok {
+				continue // already reported as a conflict above
+			}
+			value, err := resolveSecret("", file)
+			if err != nil {
+				return fmt.Errorf("webhook %s: header %s: %w", entry.URL, k, err)
+			}
+			entry.headers[k] = value
+		}
+	}
+	return nil
+}
+
+// envRef matches ${ENV_VAR} references in credential values.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveSecret returns the credential value from file if set, otherwise
+// value with ${ENV_VAR} references expanded. Setting both is an error.
+func resolveSecret(value, file string) (string, error) {
+	if file != "" {
+		if value != "" {
+			return "", errors.New("value and file are mutually exclusive")
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var missing string
+	resolved := envRef.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok && missing == "" {
+			missing = name
+		}
+		return v
+	})
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s is not set", missing)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,84 @@
+package ddns
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Batcher is implemented by providers that can submit several record
+// changes in one request. Records with values are created or replaced,
+// records without values are deleted.
+type Batcher interface {
+	UpdateBatch(ctx context.Context, recs []Record) []BatchResult
+}
+
+// BatchResult is the outcome of one record in a batch.
+type BatchResult struct {
+	Change Change
+	Err    error
+}
+
+// batch collects changes of the entries opting into batching that share a
+// provider, and submits them together.
+type batch struct {
+	provider Batcher
+	window   time.Duration // longest batch_window of the sharing entries
+	ctx      context.Context
+
+	mu      sync.Mutex
+	pending []batchOp
+}
+
+type batchOp struct {
+	rec    Record
+	result chan BatchResult
+}
+
+// submit queues recs for the next batch, so they are sent in the same
+// request, and waits for their results.
+func (b *batch) submit(ctx context.Context, recs []Record) []BatchResult {
+	ops := make([]batchOp, len(recs))
+	for i, rec := range recs {
+		ops[i] = batchOp{rec: rec, result: make(chan BatchResult, 1)}
+	}
+
+	b.mu.Lock()
+	if len(b.pending) == 0 {
+		time.AfterFunc(b.window, b.flush)
+	}
+	b.pending = append(b.pending, ops...)
+	b.mu.Unlock()
+
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		select {
+		case results[i] = <-op.result:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+		}
+	}
+	return results
+}
+
+// flush submits all pending changes and delivers per-record results.
+func (b *batch) flush() {
+	b.mu.Lock()
+	ops := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	recs := make([]Record, len(ops))
+	for i, op := range ops {
+		recs[i] = op.rec
+	}
+	if len(recs) > 1 {
+		log.Printf("DDNS: submitting batch of %d changes", len(recs))
+	}
+
+	results := b.provider.UpdateBatch(b.ctx, recs)
+	for i, op := range ops {
+		op.result <- results[i]
+	}
+}
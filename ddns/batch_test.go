@@ -0,0 +1,75 @@
+package ddns
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// batchingProvider is a fakeProvider that also records the batches it gets.
+type batchingProvider struct {
+	*fakeProvider
+	batches [][]Record
+}
+
+func (p *batchingProvider) UpdateBatch(ctx context.Context, recs []Record) []BatchResult {
+	p.mu.Lock()
+	p.batches = append(p.batches, recs)
+	p.mu.Unlock()
+
+	results := make([]BatchResult, len(recs))
+	for i, rec := range recs {
+		results[i].Change, results[i].Err = p.Update(ctx, rec)
+	}
+	return results
+}
+
+func (p *batchingProvider) batchedRecords() [][]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var batched [][]string
+	for _, recs := range p.batches {
+		var names []string
+		for _, rec := range recs {
+			names = append(names, rec.Name+" "+rec.Type)
+		}
+		batched = append(batched, names)
+	}
+	return batched
+}
+
+func TestBatchAddressFamiliesTogether(t *testing.T) {
+	p := &batchingProvider{fakeProvider: &fakeProvider{records: make(map[string][]string)}}
+	cfg := juliaConfig(0)
+	cfg.Debounce = 20 * time.Millisecond
+	cfg.BatchWindow = 20 * time.Millisecond
+	d := newTestDispatcher(t, p, nil, nil, cfg)
+
+	d.TriggerUpdate("julia", "203.0.113.50")
+	d.TriggerUpdate("julia", "2001:db8::1")
+
+	waitForWrites(t, p.fakeProvider, 2)
+	want := [][]string{{"julia.example.com A", "julia.example.com AAAA"}}
+	if got := p.batchedRecords(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("batches = %q, want %q", got, want)
+	}
+}
+
+func TestBatchOnlyOptedInEntries(t *testing.T) {
+	p := &batchingProvider{fakeProvider: &fakeProvider{records: make(map[string][]string)}}
+	batched := juliaConfig(0)
+	batched.BatchWindow = 20 * time.Millisecond
+	direct := Config{Provider: "fake", IAM: "romeo", Domain: "romeo.example.com"}
+	d := newTestDispatcher(t, p, nil, nil, batched, direct)
+
+	d.TriggerUpdate("julia", "203.0.113.50")
+	d.TriggerUpdate("romeo", "203.0.113.60")
+
+	// Both entries share the provider, but only julia's change is batched
+	waitForWrites(t, p.fakeProvider, 2)
+	want := [][]string{{"julia.example.com A"}}
+	if got := p.batchedRecords(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("batches = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,396 @@
+package ddns
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ecsMetadataHost     = "http://169.254.170.2"
+	ec2MetadataEndpoint = "http://169.254.169.254"
+
+	// credentialsRefreshWindow is how long before expiry credentials are refreshed.
+	credentialsRefreshWindow = 5 * time.Minute
+)
+
+// Credentials holds AWS credentials used to sign requests.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // zero if the credentials don't expire
+}
+
+// CredentialsProvider retrieves AWS credentials.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// NewCredentialChain returns the standard AWS credential chain: explicit keys,
+// environment variables, the shared credentials file, then the ECS and EC2
+// metadata endpoints. Credentials are cached and refreshed before they expire.
+func NewCredentialChain(accessKey, secretKey string) CredentialsProvider {
+	var chain CredentialChain
+	if accessKey != "" || secretKey != "" {
+		chain = append(chain, StaticCredentials{AccessKeyID: accessKey, SecretAccessKey: secretKey})
+	}
+	chain = append(chain,
+		EnvCredentials{},
+		&SharedCredentials{},
+		&ContainerCredentials{},
+		&EC2RoleCredentials{},
+	)
+	return &CachedCredentials{Provider: chain}
+}
+
+// CredentialChain tries each provider in order and returns the first
+// credentials found.
+type CredentialChain []CredentialsProvider
+
+// Retrieve implements CredentialsProvider.
+func (c CredentialChain) Retrieve(ctx context.Context) (Credentials, error) {
+	var reasons []string
+	for _, p := range c {
+		creds, err := p.Retrieve(ctx)
+		if err == nil {
+			return creds, nil
+		}
+		reasons = append(reasons, err.Error())
+	}
+	return Credentials{}, fmt.Errorf("no AWS credentials found (%s)", strings.Join(reasons, "; "))
+}
+
+// CachedCredentials caches credentials from Provider until shortly before
+// they expire.
+type CachedCredentials struct {
+	Provider CredentialsProvider
+
+	mu    sync.Mutex
+	creds Credentials
+}
+
+// Retrieve implements CredentialsProvider.
+func (c *CachedCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.creds.AccessKeyID != "" &&
+		(c.creds.Expires.IsZero() || time.Now().Before(c.creds.Expires.Add(-credentialsRefreshWindow))) {
+		return c.creds, nil
+	}
+
+	creds, err := c.Provider.Retrieve(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.creds = creds
+	return creds, nil
+}
+
+// StaticCredentials provides explicitly configured keys.
+type StaticCredentials Credentials
+
+// Retrieve implements CredentialsProvider.
+func (s StaticCredentials) Retrieve(context.Context) (Credentials, error) {
+	if s.AccessKeyID == "" || s.SecretAccessKey == "" {
+		return Credentials{}, errors.New("static: access key and secret key must both be set")
+	}
+	return Credentials(s), nil
+}
+
+// EnvCredentials reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN from the environment.
+type EnvCredentials struct{}
+
+// Retrieve implements CredentialsProvider.
+func (EnvCredentials) Retrieve(context.Context) (Credentials, error) {
+	creds := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return Credentials{}, errors.New("env: AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY not set")
+	}
+	return creds, nil
+}
+
+// SharedCredentials reads a profile from the shared credentials file.
+// Filename defaults to $AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials,
+// Profile to $AWS_PROFILE or "default".
+type SharedCredentials struct {
+	Filename string
+	Profile  string
+}
+
+// Retrieve implements CredentialsProvider.
+func (s *SharedCredentials) Retrieve(context.Context) (Credentials, error) {
+	filename := s.Filename
+	if filename == "" {
+		filename = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if filename == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credentials{}, fmt.Errorf("shared: %w", err)
+		}
+		filename = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := s.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("shared: %w", err)
+	}
+	defer f.Close()
+
+	var creds Credentials
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Credentials{}, fmt.Errorf("shared: %w", err)
+	}
+
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return Credentials{}, fmt.Errorf("shared: profile %q not found in %s", profile, filename)
+	}
+	return creds, nil
+}
+
+// ContainerCredentials fetches credentials from the ECS container metadata
+// endpoint. Endpoint defaults to $AWS_CONTAINER_CREDENTIALS_FULL_URI, or
+// $AWS_CONTAINER_CREDENTIALS_RELATIVE_URI on the ECS metadata host. Token
+// defaults to $AWS_CONTAINER_AUTHORIZATION_TOKEN.
+type ContainerCredentials struct {
+	Endpoint string
+	Token    string
+	Client   *http.Client
+}
+
+// Retrieve implements CredentialsProvider.
+func (c *ContainerCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+			endpoint = ecsMetadataHost + uri
+		} else {
+			endpoint = os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+		}
+	}
+	if endpoint == "" {
+		return Credentials{}, errors.New("ecs: container credentials endpoint not set")
+	}
+
+	token := c.Token
+	if token == "" {
+		token = os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, http.NoBody)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("ecs: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	creds, err := fetchMetadataCredentials(metadataClient(c.Client), req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("ecs: %w", err)
+	}
+	return creds, nil
+}
+
+// EC2RoleCredentials fetches instance role credentials from the EC2 instance
+// metadata service, using IMDSv2 session tokens when available. Endpoint
+// defaults to $AWS_EC2_METADATA_SERVICE_ENDPOINT or the link-local address.
+type EC2RoleCredentials struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// Retrieve implements CredentialsProvider.
+func (e *EC2RoleCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return Credentials{}, errors.New("ec2: metadata service disabled")
+	}
+
+	endpoint := e.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = ec2MetadataEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	client := metadataClient(e.Client)
+
+	token, err := e.sessionToken(ctx, client, endpoint)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("ec2: %w", err)
+	}
+
+	get := func(path string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+path, http.NoBody)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("X-aws-ec2-metadata-token", token)
+		}
+		return req, nil
+	}
+
+	// Look up the instance role name
+	req, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return Credentials{}, fmt.Errorf("ec2: %w", err)
+	}
+	body, err := doMetadataRequest(client, req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("ec2: %w", err)
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(body)), "\n")
+	if role == "" {
+		return Credentials{}, errors.New("ec2: no instance role attached")
+	}
+
+	req, err = get("/latest/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("ec2: %w", err)
+	}
+	creds, err := fetchMetadataCredentials(client, req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("ec2: %w", err)
+	}
+	return creds, nil
+}
+
+// sessionToken requests an IMDSv2 token. An empty token with a nil error
+// means the endpoint only supports IMDSv1.
+func (e *EC2RoleCredentials) sessionToken(ctx context.Context, client *http.Client, endpoint string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil // fall back to IMDSv1
+	}
+	token, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// metadataCredentials is the JSON document returned by the ECS and EC2
+// credential endpoints.
+type metadataCredentials struct {
+	Code            string    `json:"Code"`
+	Message         string    `json:"Message"`
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+func fetchMetadataCredentials(client *http.Client, req *http.Request) (Credentials, error) {
+	body, err := doMetadataRequest(client, req)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var mc metadataCredentials
+	if err := json.Unmarshal(body, &mc); err != nil {
+		return Credentials{}, fmt.Errorf("decoding credentials: %w", err)
+	}
+	if mc.Code != "" && mc.Code != "Success" {
+		return Credentials{}, fmt.Errorf("metadata returned %s: %s", mc.Code, mc.Message)
+	}
+	if mc.AccessKeyID == "" || mc.SecretAccessKey == "" {
+		return Credentials{}, errors.New("metadata returned incomplete credentials")
+	}
+
+	return Credentials{
+		AccessKeyID:     mc.AccessKeyID,
+		SecretAccessKey: mc.SecretAccessKey,
+		SessionToken:    mc.Token,
+		Expires:         mc.Expiration,
+	}, nil
+}
+
+func doMetadataRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d", req.URL.Path, resp.StatusCode)
+	}
+	return body, nil
+}
+
+func metadataClient(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+	// Metadata endpoints are link-local; fail fast when not running on AWS.
+	return &http.Client{Timeout: 2 * time.Second}
+}
@@ -0,0 +1,160 @@
+package ddns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const metadataCredentialsJSON = `{
+	"Code": "Success",
+	"AccessKeyId": "AKIDEXAMPLE",
+	"SecretAccessKey": "secret",
+	"Token": "session-token",
+	"Expiration": "2030-01-01T00:00:00Z"
+}`
+
+func TestEC2RoleCredentialsIMDSv2(t *testing.T) {
+	var tokenRequested bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" {
+			if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				t.Error("token request without TTL header")
+			}
+			tokenRequested = true
+			_, _ = w.Write([]byte("imds-token"))
+			return
+		}
+		if got := r.Header.Get("X-aws-ec2-metadata-token"); got != "imds-token" {
+			t.Errorf("%s %s: token header = %q, want imds-token", r.Method, r.URL.Path, got)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			_, _ = w.Write([]byte("who-role\n"))
+		case "/latest/meta-data/iam/security-credentials/who-role":
+			_, _ = w.Write([]byte(metadataCredentialsJSON))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	creds, err := (&EC2RoleCredentials{Endpoint: srv.URL}).Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	if !tokenRequested {
+		t.Error("IMDSv2 token was not requested")
+	}
+	want := Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+		SessionToken:    "session-token",
+		Expires:         time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if creds != want {
+		t.Errorf("Retrieve = %+v, want %+v", creds, want)
+	}
+}
+
+func TestContainerCredentialsFullURI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/credentials/abc" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "container-token" {
+			t.Errorf("Authorization = %q, want container-token", got)
+		}
+		_, _ = w.Write([]byte(metadataCredentialsJSON))
+	}))
+	defer srv.Close()
+
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/v2/credentials/abc")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "container-token")
+
+	creds, err := (&ContainerCredentials{}).Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	if creds.AccessKeyID != "AKIDEXAMPLE" || creds.SessionToken != "session-token" {
+		t.Errorf("Retrieve = %+v", creds)
+	}
+}
+
+func TestSignRequestSessionToken(t *testing.T) {
+	sign := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "https://"+route53Host+"/2013-04-01/change/C1", nil)
+		signRequest(req, nil, Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: token})
+		return req
+	}
+
+	req := sign("session-token")
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "session-token" {
+		t.Errorf("X-Amz-Security-Token = %q, want session-token", got)
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("security token not in signed headers: %s", auth)
+	}
+
+	// The token is part of the signature, so another token must change it
+	other := sign("other-token").Header.Get("Authorization")
+	if signature(auth) == signature(other) {
+		t.Error("signature doesn't depend on the session token")
+	}
+
+	if got := sign("").Header.Get("X-Amz-Security-Token"); got != "" {
+		t.Errorf("X-Amz-Security-Token = %q without a session token", got)
+	}
+}
+
+func signature(auth string) string {
+	_, sig, _ := strings.Cut(auth, "Signature=")
+	return sig
+}
+
+// countingCredentials returns credentials expiring after ttl and counts calls.
+type countingCredentials struct {
+	ttl   time.Duration
+	calls int
+}
+
+func (c *countingCredentials) Retrieve(context.Context) (Credentials, error) {
+	c.calls++
+	return Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", Expires: time.Now().Add(c.ttl)}, nil
+}
+
+func TestCachedCredentialsRefresh(t *testing.T) {
+	ctx := context.Background()
+
+	// Far from expiry, credentials are cached
+	fresh := &countingCredentials{ttl: time.Hour}
+	cached := &CachedCredentials{Provider: fresh}
+	for range 3 {
+		if _, err := cached.Retrieve(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fresh.calls != 1 {
+		t.Errorf("fresh credentials retrieved %d times, want 1", fresh.calls)
+	}
+
+	// Within the refresh window, they are retrieved again before expiring
+	expiring := &countingCredentials{ttl: credentialsRefreshWindow - time.Minute}
+	cached = &CachedCredentials{Provider: expiring}
+	for range 2 {
+		if _, err := cached.Retrieve(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expiring.calls != 2 {
+		t.Errorf("expiring credentials retrieved %d times, want 2", expiring.calls)
+	}
+}
@@ -1,57 +1,277 @@
 package ddns
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tracyhatemice/who/event"
+)
+
+// Change status values reported by providers.
+const (
+	ChangeStatusPending = "PENDING"
+	ChangeStatusInSync  = "INSYNC"
+)
+
+// Entry modes.
+const (
+	ModeAddress = "address" // A/AAAA records with the addresses of IAM (default)
+	ModeCNAME   = "cname"   // CNAME to the domain of the first active target
 )
 
+// liveCacheTTL is how long a record value read from or written to the
+// provider is trusted before it is looked up again.
+const liveCacheTTL = 10 * time.Minute
+
+// Record is a DNS resource record set. Values are in zone file format, so
+// TXT values are quoted.
+type Record struct {
+	Name   string
+	Type   string
+	Values []string
+	TTL    int
+}
+
 // Provider defines the interface for DNS providers.
 type Provider interface {
-	Update(domain, ip string, ttl int) error
+	// Update creates or replaces the record set.
+	Update(ctx context.Context, rec Record) (Change, error)
+	// Delete removes the record set. Deleting a missing record is not an error.
+	Delete(ctx context.Context, rec Record) (Change, error)
+	// Get returns the current record set, with no values if it doesn't exist.
+	Get(ctx context.Context, name, recordType string) (Record, error)
+}
+
+// SyncWaiter is implemented by providers that can report when a submitted
+// change has propagated to all of their authoritative servers.
+type SyncWaiter interface {
+	WaitForSync(ctx context.Context, c Change, timeout time.Duration) (Change, error)
+}
+
+// Change describes a DNS change submitted to a provider.
+type Change struct {
+	ID          string
+	Status      string
+	SubmittedAt time.Time
+}
+
+// Status reports the outcome of the most recent update for an entry.
+type Status struct {
+	IAM          string    `json:"iam"`
+	Domain       string    `json:"domain"`
+	Type         string    `json:"type,omitempty"`
+	Values       []string  `json:"values,omitempty"`
+	Deleted      bool      `json:"deleted,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at,omitzero"`
+	Error        string    `json:"error,omitempty"`
+	ChangeID     string    `json:"change_id,omitempty"`
+	ChangeStatus string    `json:"change_status,omitempty"`
+	SyncedAt     time.Time `json:"synced_at,omitzero"`
+	Latency      string    `json:"latency,omitempty"`
+	Unchanged    bool      `json:"unchanged,omitempty"`
+}
+
+// LookupFunc returns the current IP of a name, like Store.Get.
+type LookupFunc func(name string) (string, bool)
+
+// SeenFunc returns when a name last checked in, or false if it has no
+// address.
+type SeenFunc func(name string) (time.Time, bool)
+
+// defaultStaleAfter is how long a CNAME target may go without checking in
+// before the CNAME moves to the next one.
+const defaultStaleAfter = 10 * time.Minute
+
+// Target is a CNAME destination used while its IAM name has an address and
+// checked in within the entry's StaleAfter.
+type Target struct {
+	IAM    string
+	Domain string
 }
 
 // Entry represents a DDNS configuration matched to a provider.
 type Entry struct {
-	IAM       string
-	Domain    string
-	IPVersion string
-	TTL       int
-	Provider  Provider
+	IAM         string
+	Domain      string
+	IPVersion   string
+	Mode        string
+	Targets     []Target
+	StaleAfter  time.Duration // CNAME targets not seen for this long are skipped
+	TXT         bool
+	TTL         int
+	SyncTimeout time.Duration
+	Debounce    time.Duration
+	MinInterval time.Duration
+	Provider    Provider
+
+	// Suffix, if valid, is the interface ID combined with the first
+	// PrefixLength bits of IAM's address to form the published address.
+	Suffix       netip.Addr
+	PrefixLength int
+
+	// guarded by Dispatcher.mu
+	status  map[string]Status     // keyed by record type
+	live    map[string]liveRecord // keyed by record type
+	pending map[string]Record     // latest unapplied record per type
+
+	batch *batch        // shared with other entries of the provider, nil if not batched
+	wake  chan struct{} // signals the entry's worker that pending changed
+}
+
+// liveRecord is a record's values as last seen in or written to DNS.
+type liveRecord struct {
+	values []string
+	at     time.Time
 }
 
 // Config holds provider-specific configuration.
 type Config struct {
-	Provider  string
-	Domain    string
-	IPVersion string
-	IAM       string
-	AccessKey string
-	SecretKey string
-	ZoneID    string
-	TTL       int
+	Provider     string
+	Domain       string
+	IPVersion    string
+	IAM          string
+	Mode         string
+	Targets      []Target
+	StaleAfter   time.Duration
+	TXT          bool
+	AccessKey    string
+	SecretKey    string
+	ZoneID       string
+	TTL          int
+	SyncTimeout  time.Duration
+	Debounce     time.Duration
+	MinInterval  time.Duration
+	BatchWindow  time.Duration
+	IPv6Suffix   string
+	PrefixLength int
 }
 
 // Dispatcher manages DDNS entries and triggers updates.
 type Dispatcher struct {
-	entries map[string][]*Entry // keyed by IAM name, multiple entries per IAM
+	entries   map[string][]*Entry // keyed by IAM name, multiple entries per IAM
+	ordered   []*Entry            // all entries in config order
+	aliases   map[string][]string // alias → member names
+	aliasesOf map[string][]string // member name → aliases with DDNS entries
+	cnamesOf  map[string][]*Entry // target name → CNAME entries
+	lookup    LookupFunc
+	seen      SeenFunc
+	batches   map[Provider]*batch // batches of opted-in entries by provider
+
+	ctx    context.Context // cancelled by Close
+	cancel context.CancelFunc
+	wg     sync.WaitGroup // workers and sync waits
+
+	mu sync.Mutex // protects entry status, live cache and pending records
+}
+
+// NewDispatcher creates a Dispatcher from configuration. Entries whose IAM is
+// one of aliases publish the addresses of all members, resolved with lookup.
+// CNAME entries follow the first target that seen reports as recent.
+func NewDispatcher(configs []Config, aliases map[string][]string, lookup LookupFunc, seen SeenFunc) *Dispatcher {
+	return newDispatcher(configs, aliases, lookup, seen, newProvider)
 }
 
-// NewDispatcher creates a Dispatcher from configuration.
-func NewDispatcher(configs []Config) *Dispatcher {
-	d := &Dispatcher{entries: make(map[string][]*Entry)}
+// newProvider creates the provider configured by cfg.
+func newProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case "route53":
+		return NewRoute53(NewCredentialChain(cfg.AccessKey, cfg.SecretKey), cfg.ZoneID), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+	}
+}
+
+// newDispatcher is NewDispatcher with providers created by newProvider, so
+// tests can substitute their own.
+func newDispatcher(configs []Config, aliases map[string][]string, lookup LookupFunc, seen SeenFunc, newProvider func(Config) (Provider, error)) *Dispatcher {
+	d := &Dispatcher{
+		entries:   make(map[string][]*Entry),
+		aliases:   aliases,
+		aliasesOf: make(map[string][]string),
+		cnamesOf:  make(map[string][]*Entry),
+		lookup:    lookup,
+		seen:      seen,
+		batches:   make(map[Provider]*batch),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	// Entries for the same zone and credentials share a provider, so their
+	// changes can be batched
+	providers := make(map[string]Provider)
 
 	for _, cfg := range configs {
-		if cfg.IAM == "" {
-			log.Printf("DDNS: skipping entry with empty IAM")
+		switch cfg.Mode {
+		case "", ModeAddress:
+			cfg.Mode = ModeAddress
+			if cfg.IAM == "" {
+				log.Printf("DDNS: skipping entry with empty IAM")
+				continue
+			}
+		case ModeCNAME:
+			if len(cfg.Targets) == 0 {
+				log.Printf("DDNS: skipping cname entry %q without targets", cfg.Domain)
+				continue
+			}
+			if cfg.TXT {
+				log.Printf("DDNS: TXT records can't coexist with the CNAME at %q, ignoring txt", cfg.Domain)
+				cfg.TXT = false
+			}
+			if cfg.StaleAfter <= 0 {
+				cfg.StaleAfter = defaultStaleAfter
+			}
+		default:
+			log.Printf("DDNS: unknown mode %q for %q, skipping", cfg.Mode, cfg.Domain)
 			continue
 		}
 
-		var provider Provider
-		switch cfg.Provider {
-		case "route53":
-			provider = NewRoute53(cfg.AccessKey, cfg.SecretKey, cfg.ZoneID)
-		default:
-			log.Printf("DDNS: unknown provider %q for IAM %q, skipping", cfg.Provider, cfg.IAM)
-			continue
+		var suffix netip.Addr
+		if cfg.IPv6Suffix != "" {
+			var err error
+			if suffix, err = ParseSuffix(cfg.IPv6Suffix); err != nil {
+				log.Printf("DDNS: invalid ipv6_suffix for %q: %v, skipping", cfg.Domain, err)
+				continue
+			}
+			if cfg.Mode == ModeCNAME || cfg.IPVersion == "ipv4" {
+				log.Printf("DDNS: ipv6_suffix requires an ipv6 address entry for %q, skipping", cfg.Domain)
+				continue
+			}
+			cfg.IPVersion = "ipv6"
+			if cfg.PrefixLength < 0 || cfg.PrefixLength > 128 {
+				log.Printf("DDNS: invalid prefix_length %d for %q (must be between 0 and 128), skipping", cfg.PrefixLength, cfg.Domain)
+				continue
+			}
+			if cfg.PrefixLength == 0 {
+				cfg.PrefixLength = 64
+			}
+		}
+
+		key := cfg.Provider + "\x00" + cfg.ZoneID + "\x00" + cfg.AccessKey + "\x00" + cfg.SecretKey
+		provider, ok := providers[key]
+		if !ok {
+			var err error
+			if provider, err = newProvider(cfg); err != nil {
+				log.Printf("DDNS: %v for IAM %q, skipping", err, cfg.IAM)
+				continue
+			}
+			providers[key] = provider
+		}
+
+		// Batching delays every update by the window, so each entry opts in;
+		// entries that don't call the provider directly
+		var b *batch
+		if batcher, ok := provider.(Batcher); ok && cfg.BatchWindow > 0 {
+			if b, ok = d.batches[provider]; ok {
+				b.window = max(b.window, cfg.BatchWindow)
+			} else {
+				b = &batch{provider: batcher, window: cfg.BatchWindow, ctx: d.ctx}
+				d.batches[provider] = b
+			}
 		}
 
 		ttl := cfg.TTL
@@ -60,35 +280,379 @@ func NewDispatcher(configs []Config) *Dispatcher {
 		}
 
 		entry := &Entry{
-			IAM:       cfg.IAM,
-			Domain:    cfg.Domain,
-			IPVersion: cfg.IPVersion,
-			TTL:       ttl,
-			Provider:  provider,
+			IAM:          cfg.IAM,
+			Domain:       cfg.Domain,
+			IPVersion:    cfg.IPVersion,
+			Mode:         cfg.Mode,
+			Targets:      cfg.Targets,
+			StaleAfter:   cfg.StaleAfter,
+			TXT:          cfg.TXT,
+			TTL:          ttl,
+			SyncTimeout:  cfg.SyncTimeout,
+			Debounce:     cfg.Debounce,
+			MinInterval:  cfg.MinInterval,
+			Provider:     provider,
+			Suffix:       suffix,
+			PrefixLength: cfg.PrefixLength,
+			batch:        b,
+			status:       make(map[string]Status),
+			live:         make(map[string]liveRecord),
+			pending:      make(map[string]Record),
+			wake:         make(chan struct{}, 1),
 		}
 
-		d.entries[cfg.IAM] = append(d.entries[cfg.IAM], entry)
+		if entry.Mode == ModeCNAME {
+			for _, target := range entry.Targets {
+				d.cnamesOf[target.IAM] = append(d.cnamesOf[target.IAM], entry)
+			}
+		} else {
+			if _, isAlias := aliases[cfg.IAM]; isAlias && len(d.entries[cfg.IAM]) == 0 {
+				for _, member := range aliases[cfg.IAM] {
+					d.aliasesOf[member] = append(d.aliasesOf[member], cfg.IAM)
+				}
+			}
+			d.entries[cfg.IAM] = append(d.entries[cfg.IAM], entry)
+		}
+		d.ordered = append(d.ordered, entry)
+
+		d.wg.Add(1)
+		go d.work(entry)
+	}
+
+	if interval := d.cnameInterval(); interval > 0 {
+		d.wg.Go(func() { d.watchCNAMEs(interval) })
 	}
 	return d
 }
 
-// TriggerUpdate checks if the name has DDNS configs and updates async.
-// This is non-blocking - it spawns a goroutine for each update.
+// Handle implements event.Subscriber: records are updated with the new IP,
+// or deleted when a name is removed.
+func (d *Dispatcher) Handle(e event.Event) {
+	if e.New == "" {
+		d.TriggerDelete(e.Name)
+	} else {
+		d.TriggerUpdate(e.Name, e.New)
+	}
+}
+
+// TriggerUpdate checks if the name, an alias including it, or a CNAME
+// targeting it has DDNS configs and queues updates for each entry's worker.
+// This is non-blocking.
 func (d *Dispatcher) TriggerUpdate(name, ip string) {
-	entries, ok := d.entries[name]
-	if !ok {
-		return // No DDNS config for this name
+	recordType := RecordType(ip)
+	for _, entry := range d.entries[name] {
+		if !entry.manages(recordType) {
+			continue // e.g. the IPv6 check-in of a dual-stack host for an ipv4 entry
+		}
+		d.enqueue(entry, entry.record(recordType, []string{ip}))
 	}
+	d.refreshAliases(name)
+	d.refreshCNAMEs(name)
+}
 
-	for _, entry := range entries {
-		// Async update - don't block the HTTP handler
-		go func(e *Entry) {
-			log.Printf("DDNS: updating %s -> %s for IAM %s", e.Domain, ip, name)
-			if err := e.Provider.Update(e.Domain, ip, e.TTL); err != nil {
-				log.Printf("DDNS: failed to update %s: %v", e.Domain, err)
-			} else {
-				log.Printf("DDNS: successfully updated %s -> %s", e.Domain, ip)
+// TriggerDelete removes the records of every DDNS entry for name, e.g. when
+// the name expires, and drops its address from aliases. This is non-blocking.
+func (d *Dispatcher) TriggerDelete(name string) {
+	for _, entry := range d.entries[name] {
+		for _, recordType := range entry.recordTypes() {
+			d.enqueue(entry, entry.record(recordType, nil))
+		}
+	}
+	d.refreshAliases(name)
+	d.refreshCNAMEs(name)
+}
+
+// refreshAliases republishes every alias entry that includes member, with
+// one record set per address family holding all current member addresses.
+func (d *Dispatcher) refreshAliases(member string) {
+	for _, alias := range d.aliasesOf[member] {
+		byType := make(map[string][]string)
+		for _, name := range d.aliases[alias] {
+			if ip, ok := d.lookup(name); ok {
+				recordType := RecordType(ip)
+				if !slices.Contains(byType[recordType], ip) {
+					byType[recordType] = append(byType[recordType], ip)
+				}
+			}
+		}
+
+		for _, entry := range d.entries[alias] {
+			for _, recordType := range entry.recordTypes() {
+				values := byType[recordType]
+				slices.Sort(values)
+				d.enqueue(entry, entry.record(recordType, values))
 			}
-		}(entry)
+		}
 	}
 }
+
+// Close cancels in-flight updates, drops queued ones and waits for the
+// workers to return.
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// Status returns the most recent update status of every entry and record
+// type in config order.
+func (d *Dispatcher) Status() []Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var statuses []Status
+	for _, e := range d.ordered {
+		if len(e.status) == 0 {
+			statuses = append(statuses, Status{IAM: e.IAM, Domain: e.Domain})
+			continue
+		}
+		for _, recordType := range []string{"A", "AAAA", "CNAME", "TXT"} {
+			if status, ok := e.status[recordType]; ok {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	return statuses
+}
+
+// apply publishes recs, or deletes those without values, except the ones
+// DNS already matches; a batched entry submits them in one request. If
+// configured, it then waits for the changes to propagate. It reports whether
+// the provider was called to change a record, and whether any change
+// succeeded.
+func (d *Dispatcher) apply(ctx context.Context, e *Entry, recs []Record) (called, changed bool) {
+	var submit []Record
+	for _, rec := range recs {
+		if !d.isLive(ctx, e, rec) {
+			submit = append(submit, rec)
+			continue
+		}
+		log.Printf("DDNS: %s %s already %s, skipping update", rec.Type, e.Domain, describe(rec))
+		d.setStatus(e, rec.Type, func(s *Status) {
+			*s = Status{
+				IAM: e.IAM, Domain: e.Domain, Type: rec.Type, Values: rec.Values,
+				Deleted: len(rec.Values) == 0, UpdatedAt: time.Now(), Unchanged: true,
+			}
+		})
+	}
+	if len(submit) == 0 || ctx.Err() != nil {
+		return false, false // nothing to do, or shutting down
+	}
+
+	for _, rec := range submit {
+		log.Printf("DDNS: updating %s %s -> %s for IAM %s", rec.Type, e.Domain, describe(rec), e.IAM)
+	}
+	start := time.Now()
+	for i, result := range d.submit(ctx, e, submit) {
+		if d.applied(ctx, e, submit[i], result, start) {
+			changed = true
+		}
+	}
+	return true, changed
+}
+
+// applied records the result of submitting rec and, if configured, waits
+// for the change to propagate. It reports whether the change succeeded.
+func (d *Dispatcher) applied(ctx context.Context, e *Entry, rec Record, result BatchResult, start time.Time) bool {
+	change, err := result.Change, result.Err
+	d.setStatus(e, rec.Type, func(s *Status) {
+		*s = Status{
+			IAM: e.IAM, Domain: e.Domain, Type: rec.Type, Values: rec.Values,
+			Deleted: len(rec.Values) == 0, UpdatedAt: start,
+		}
+		if err != nil {
+			s.Error = err.Error()
+			delete(e.live, rec.Type)
+			return
+		}
+		s.ChangeID = change.ID
+		s.ChangeStatus = change.Status
+		e.live[rec.Type] = liveRecord{values: rec.Values, at: start}
+	})
+	if err != nil {
+		log.Printf("DDNS: failed to update %s %s: %v", rec.Type, e.Domain, err)
+		return false
+	}
+	log.Printf("DDNS: successfully updated %s %s -> %s (change %s %s)", rec.Type, e.Domain, describe(rec), change.ID, change.Status)
+
+	waiter, ok := e.Provider.(SyncWaiter)
+	if !ok || e.SyncTimeout <= 0 || change.ID == "" {
+		return true
+	}
+
+	// Wait for propagation without holding up the entry's next update
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.waitForSync(ctx, e, waiter, rec, change, start)
+	}()
+	return true
+}
+
+// describe returns rec's values for logging.
+func describe(rec Record) string {
+	if len(rec.Values) == 0 {
+		return "(deleted)"
+	}
+	return strings.Join(rec.Values, ",")
+}
+
+// waitForSync waits for a submitted change to propagate and records the
+// outcome in the entry's status.
+func (d *Dispatcher) waitForSync(ctx context.Context, e *Entry, waiter SyncWaiter, rec Record, change Change, start time.Time) {
+	desc := describe(rec)
+	change, err := waiter.WaitForSync(ctx, change, e.SyncTimeout)
+	latency := time.Since(start).Round(time.Millisecond)
+	d.setStatus(e, rec.Type, func(s *Status) {
+		if s.ChangeID != change.ID {
+			return // superseded by a newer update
+		}
+		s.ChangeStatus = change.Status
+		if err != nil {
+			s.Error = err.Error()
+			return
+		}
+		s.SyncedAt = time.Now()
+		s.Latency = latency.String()
+	})
+	if err != nil {
+		log.Printf("DDNS: %s %s -> %s not in sync: %v", rec.Type, e.Domain, desc, err)
+		return
+	}
+	log.Printf("DDNS: %s %s -> %s in sync after %s", rec.Type, e.Domain, desc, latency)
+}
+
+// submit sends recs to the entry's provider, together in one batch with
+// changes of other batched entries if the entry opted into batching.
+func (d *Dispatcher) submit(ctx context.Context, e *Entry, recs []Record) []BatchResult {
+	if e.batch != nil {
+		return e.batch.submit(ctx, recs)
+	}
+	results := make([]BatchResult, len(recs))
+	for i, rec := range recs {
+		if len(rec.Values) == 0 {
+			results[i].Change, results[i].Err = e.Provider.Delete(ctx, rec)
+		} else {
+			results[i].Change, results[i].Err = e.Provider.Update(ctx, rec)
+		}
+	}
+	return results
+}
+
+// isLive reports whether DNS already holds rec's values, looking the record
+// up from the provider when the cached value is unknown or stale.
+func (d *Dispatcher) isLive(ctx context.Context, e *Entry, rec Record) bool {
+	d.mu.Lock()
+	if live, ok := e.live[rec.Type]; ok && time.Since(live.at) < liveCacheTTL {
+		defer d.mu.Unlock()
+		return sameValues(live.values, rec.Values)
+	}
+	d.mu.Unlock()
+
+	current, err := e.Provider.Get(ctx, e.Domain, rec.Type)
+	if err != nil {
+		log.Printf("DDNS: failed to look up %s %s: %v", rec.Type, e.Domain, err)
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e.live[rec.Type] = liveRecord{values: current.Values, at: time.Now()}
+	return sameValues(current.Values, rec.Values)
+}
+
+// record builds a record set for the entry. Entries with a suffix publish
+// addresses derived from the prefixes of the given values.
+func (e *Entry) record(recordType string, values []string) Record {
+	if e.Suffix.IsValid() && recordType == "AAAA" {
+		derived := make([]string, 0, len(values))
+		for _, ip := range values {
+			if addr, ok := DeriveAddress(ip, e.PrefixLength, e.Suffix); ok {
+				derived = append(derived, addr)
+			}
+		}
+		slices.Sort(derived)
+		values = slices.Compact(derived)
+	}
+	return Record{Name: e.Domain, Type: recordType, Values: values, TTL: e.TTL}
+}
+
+// manages reports whether the entry publishes records of recordType.
+func (e *Entry) manages(recordType string) bool {
+	return slices.Contains(e.recordTypes(), recordType)
+}
+
+// recordTypes returns the address record types managed by the entry.
+func (e *Entry) recordTypes() []string {
+	if e.Mode == ModeCNAME {
+		return nil
+	}
+	switch e.IPVersion {
+	case "ipv4":
+		return []string{"A"}
+	case "ipv6":
+		return []string{"AAAA"}
+	default:
+		return []string{"A", "AAAA"}
+	}
+}
+
+// sameValues reports whether a and b hold the same values in any order.
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// RecordType returns the address record type for ip: AAAA for IPv6, A otherwise.
+func RecordType(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// ParseSuffix parses an IPv6 interface ID such as "::1234:5678:9abc:def0".
+func ParseSuffix(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if !addr.Is6() || addr.Is4In6() {
+		return netip.Addr{}, fmt.Errorf("%s is not an IPv6 address", s)
+	}
+	return addr, nil
+}
+
+// DeriveAddress combines the first prefixLength bits of ip, such as a
+// router's address in a delegated prefix, with the remaining bits of suffix.
+// It returns false if ip isn't an IPv6 address.
+func DeriveAddress(ip string, prefixLength int, suffix netip.Addr) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() || addr.Is4In6() {
+		return "", false
+	}
+	prefix, host := addr.As16(), suffix.As16()
+	for i := range prefix {
+		var mask byte
+		switch bits := prefixLength - i*8; {
+		case bits >= 8:
+			mask = 0xff
+		case bits > 0:
+			mask = 0xff << (8 - bits)
+		}
+		prefix[i] = prefix[i]&mask | host[i]&^mask
+	}
+	return netip.AddrFrom16(prefix).String(), true
+}
+
+func (d *Dispatcher) setStatus(e *Entry, recordType string, fn func(*Status)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := e.status[recordType]
+	fn(&status)
+	e.status[recordType] = status
+}
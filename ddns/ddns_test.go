@@ -0,0 +1,67 @@
+package ddns
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseSuffix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "::1234:5678:9abc:def0", want: "::1234:5678:9abc:def0"},
+		{in: "::1", want: "::1"},
+		{in: "2001:db8::1", want: "2001:db8::1"},
+		{in: "192.0.2.1", wantErr: true},
+		{in: "::ffff:192.0.2.1", wantErr: true},
+		{in: "::1/64", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSuffix(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSuffix(%q) = %s, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("ParseSuffix(%q) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestDeriveAddress(t *testing.T) {
+	suffix := netip.MustParseAddr("::1234:5678:9abc:def0")
+	tests := []struct {
+		ip           string
+		prefixLength int
+		want         string // empty if no address is derived
+	}{
+		{"2001:db8:aaaa:bbbb:cccc:dddd:eeee:ffff", 64, "2001:db8:aaaa:bbbb:1234:5678:9abc:def0"},
+		{"2001:db8:aaaa:bbbb:cccc:dddd:eeee:ffff", 60, "2001:db8:aaaa:bbb0:1234:5678:9abc:def0"},
+		{"2001:db8:aaaa:bbbb:cccc:dddd:eeee:ffff", 56, "2001:db8:aaaa:bb00:1234:5678:9abc:def0"},
+		{"2001:db8:aaaa:bbbb:cccc:dddd:eeee:ffff", 48, "2001:db8:aaaa:0:1234:5678:9abc:def0"},
+		// Not on a byte boundary: 0xbb masked to its top 3 bits is 0xa0
+		{"2001:db8:aaaa:bbbb:cccc:dddd:eeee:ffff", 51, "2001:db8:aaaa:a000:1234:5678:9abc:def0"},
+		{"2001:db8:aaaa:bbbb:cccc:dddd:eeee:ffff", 128, "2001:db8:aaaa:bbbb:cccc:dddd:eeee:ffff"},
+		{"2001:db8:aaaa:bbbb:cccc:dddd:eeee:ffff", 0, "::1234:5678:9abc:def0"},
+		{"203.0.113.10", 64, ""},
+		{"::ffff:203.0.113.10", 64, ""},
+		{"not an address", 64, ""},
+	}
+	for _, tt := range tests {
+		got, ok := DeriveAddress(tt.ip, tt.prefixLength, suffix)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("DeriveAddress(%s, %d) = %q, %t, want %q", tt.ip, tt.prefixLength, got, ok, tt.want)
+		}
+	}
+
+	// Suffix bits inside the prefix are ignored
+	got, _ := DeriveAddress("2001:db8:aaaa:bbbb::1", 56, netip.MustParseAddr("ffff:ffff:ffff:ffff::1"))
+	if want := "2001:db8:aaaa:bbff::1"; got != want {
+		t.Errorf("DeriveAddress with a long suffix = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package ddns
+
+import (
+	"fmt"
+	"time"
+)
+
+// refreshCNAMEs points every CNAME entry targeting name at its first live
+// target, or deletes the CNAME if none is.
+func (d *Dispatcher) refreshCNAMEs(name string) {
+	for _, entry := range d.cnamesOf[name] {
+		d.refreshCNAME(entry)
+	}
+}
+
+// refreshCNAME queues the entry's CNAME unless DNS is known to point at its
+// first live target already and no other target is queued. A failed write
+// clears what is known, so the next refresh retries it.
+func (d *Dispatcher) refreshCNAME(e *Entry) {
+	var values []string
+	for _, t := range e.Targets {
+		if d.live(t.IAM, e.StaleAfter) {
+			values = []string{fqdn(t.Domain)}
+			break
+		}
+	}
+
+	d.mu.Lock()
+	live, known := e.live["CNAME"]
+	_, queued := e.pending["CNAME"]
+	d.mu.Unlock()
+	if queued || !known || !sameValues(live.values, values) {
+		d.enqueue(e, e.record("CNAME", values))
+	}
+}
+
+// live reports whether name has an address and checked in within staleAfter.
+func (d *Dispatcher) live(name string, staleAfter time.Duration) bool {
+	if _, ok := d.lookup(name); !ok {
+		return false
+	}
+	seen, ok := d.seen(name)
+	return ok && time.Since(seen) < staleAfter
+}
+
+// cnameInterval returns how often CNAME targets should be re-checked for
+// going stale, or 0 if there are no CNAME entries.
+func (d *Dispatcher) cnameInterval() time.Duration {
+	var interval time.Duration
+	for _, e := range d.ordered {
+		if e.Mode == ModeCNAME {
+			i := min(max(e.StaleAfter/10, time.Second), time.Minute)
+			if interval == 0 || i < interval {
+				interval = i
+			}
+		}
+	}
+	return interval
+}
+
+// watchCNAMEs periodically re-checks every CNAME entry, so a target going
+// stale moves the CNAME even when nothing checks in, until Close.
+func (d *Dispatcher) watchCNAMEs(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, e := range d.ordered {
+			if e.Mode == ModeCNAME {
+				d.refreshCNAME(e)
+			}
+		}
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// txtRecord builds the metadata TXT record published next to an entry's
+// address records, or an empty record to delete it once no address record
+// is left.
+func (d *Dispatcher) txtRecord(e *Entry) Record {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, recordType := range e.recordTypes() {
+		if len(e.live[recordType].values) > 0 {
+			value := fmt.Sprintf("last-updated=%s;source=who;iam=%s",
+				time.Now().UTC().Format(time.RFC3339), e.IAM)
+			return e.record("TXT", []string{fmt.Sprintf("%q", value)})
+		}
+	}
+	return e.record("TXT", nil)
+}
@@ -0,0 +1,112 @@
+package ddns
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// targets is the last check-in of CNAME targets, as lookup and seen
+// functions for a dispatcher.
+type targets struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newTargets(names ...string) *targets {
+	t := &targets{seen: make(map[string]time.Time)}
+	for _, name := range names {
+		t.seen[name] = time.Now()
+	}
+	return t
+}
+
+func (t *targets) set(name string, seen time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seen[name] = seen
+}
+
+func (t *targets) lookup(name string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.seen[name]
+	return "203.0.113.50", ok
+}
+
+func (t *targets) lastSeen(name string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seen, ok := t.seen[name]
+	return seen, ok
+}
+
+// wwwConfig is a CNAME entry for www preferring site-a over site-b.
+func wwwConfig() Config {
+	return Config{
+		Provider: "fake", Domain: "www.example.com", Mode: ModeCNAME, StaleAfter: time.Minute,
+		Targets: []Target{
+			{IAM: "site-a", Domain: "site-a.example.com"},
+			{IAM: "site-b", Domain: "site-b.example.com"},
+		},
+	}
+}
+
+func cname(p *fakeProvider) string {
+	rec, _ := p.Get(context.Background(), "www.example.com", "CNAME")
+	if len(rec.Values) == 0 {
+		return ""
+	}
+	return rec.Values[0]
+}
+
+func TestCNAMEFollowsFirstLiveTarget(t *testing.T) {
+	sites := newTargets("site-a", "site-b")
+	p := &fakeProvider{records: make(map[string][]string)}
+	d := newTestDispatcher(t, p, sites.lookup, sites.lastSeen, wwwConfig())
+	e := d.ordered[0]
+
+	d.refreshCNAMEs("site-a")
+	waitForWrites(t, p, 1)
+	if got := cname(p); got != "site-a.example.com." {
+		t.Fatalf("CNAME = %q, want site-a.example.com.", got)
+	}
+
+	// site-a is still stored, but hasn't checked in for too long
+	sites.set("site-a", time.Now().Add(-time.Hour))
+	d.refreshCNAME(e)
+	waitForWrites(t, p, 2)
+	if got := cname(p); got != "site-b.example.com." {
+		t.Fatalf("CNAME = %q, want site-b.example.com.", got)
+	}
+
+	// Re-checking without a change doesn't queue another write
+	d.refreshCNAME(e)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(p.writeTimes()); n != 2 {
+		t.Errorf("got %d writes, want 2", n)
+	}
+}
+
+func TestCNAMERetriesFailedWrite(t *testing.T) {
+	sites := newTargets("site-a", "site-b")
+	p := &fakeProvider{records: make(map[string][]string), failures: 1}
+	d := newTestDispatcher(t, p, sites.lookup, sites.lastSeen, wwwConfig())
+
+	// The dispatcher checks CNAMEs when it starts; that write fails
+	deadline := time.Now().Add(5 * time.Second)
+	for d.Status()[0].Error == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("CNAME write wasn't attempted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The target is unchanged, but wasn't written, so it is retried
+	d.refreshCNAME(d.ordered[0])
+	waitForWrites(t, p, 1)
+	if got := cname(p); got != "site-a.example.com." {
+		t.Errorf("CNAME = %q, want site-a.example.com.", got)
+	}
+}
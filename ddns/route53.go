@@ -2,19 +2,29 @@ package ddns
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
 
 const route53Host = "route53.amazonaws.com"
 
+// Route53 limits per ChangeResourceRecordSets request. UPSERT changes count
+// twice towards both.
+const (
+	route53MaxRecords    = 1000
+	route53MaxValueChars = 32000
+)
+
 // XML structures for Route53 API
 type changeRequest struct {
 	XMLName     xml.Name    `xml:"ChangeResourceRecordSetsRequest"`
@@ -42,47 +52,220 @@ type resourceRecord struct {
 	Value string `xml:"Value"`
 }
 
+type listResourceRecordSetsResponse struct {
+	ResourceRecordSets []resourceRecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+}
+
+type changeInfoResponse struct {
+	ChangeInfo changeInfo `xml:"ChangeInfo"`
+}
+
+type changeInfo struct {
+	ID          string    `xml:"Id"`
+	Status      string    `xml:"Status"`
+	SubmittedAt time.Time `xml:"SubmittedAt"`
+}
+
+func (ci changeInfo) toChange() Change {
+	return Change{
+		ID:          strings.TrimPrefix(ci.ID, "/change/"),
+		Status:      ci.Status,
+		SubmittedAt: ci.SubmittedAt,
+	}
+}
+
 // Route53 implements the Provider interface for AWS Route53.
 type Route53 struct {
-	accessKey string
-	secretKey string
-	zoneID    string
-	client    *http.Client
+	creds        CredentialsProvider
+	zoneID       string
+	endpoint     string
+	pollInterval time.Duration
+	client       *http.Client
 }
 
 // NewRoute53 creates a new Route53 provider.
-func NewRoute53(accessKey, secretKey, zoneID string) *Route53 {
+func NewRoute53(creds CredentialsProvider, zoneID string) *Route53 {
 	return &Route53{
-		accessKey: accessKey,
-		secretKey: secretKey,
-		zoneID:    zoneID,
-		client:    &http.Client{Timeout: 30 * time.Second},
+		creds:        creds,
+		zoneID:       zoneID,
+		endpoint:     "https://" + route53Host,
+		pollInterval: 5 * time.Second,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Update implements Provider.Update for Route53 with an UPSERT.
+func (r *Route53) Update(ctx context.Context, rec Record) (Change, error) {
+	return r.submit(ctx, []change{newChange("UPSERT", rec)})
+}
+
+// Delete implements Provider.Delete for Route53. Route53 only deletes a
+// record set whose values match exactly, so the current record is looked up
+// first; deleting a record that doesn't exist is a no-op.
+func (r *Route53) Delete(ctx context.Context, rec Record) (Change, error) {
+	current, err := r.Get(ctx, rec.Name, rec.Type)
+	if err != nil {
+		return Change{}, fmt.Errorf("looking up record: %w", err)
+	}
+	if len(current.Values) == 0 {
+		return Change{}, nil
+	}
+	return r.submit(ctx, []change{newChange("DELETE", current)})
+}
+
+// UpdateBatch implements Batcher. Changes are split into requests that fit
+// Route53's limits. Route53 applies a request atomically, so if a request
+// with several changes is rejected, each is retried alone to keep one bad
+// record from failing the others.
+func (r *Route53) UpdateBatch(ctx context.Context, recs []Record) []BatchResult {
+	results := make([]BatchResult, len(recs))
+
+	var changes []change
+	var index []int // changes[i] is for recs[index[i]]
+	for i, rec := range recs {
+		if len(rec.Values) > 0 {
+			changes = append(changes, newChange("UPSERT", rec))
+			index = append(index, i)
+			continue
+		}
+
+		current, err := r.Get(ctx, rec.Name, rec.Type)
+		if err != nil {
+			results[i].Err = fmt.Errorf("looking up record: %w", err)
+			continue
+		}
+		if len(current.Values) > 0 {
+			changes = append(changes, newChange("DELETE", current))
+			index = append(index, i)
+		}
+	}
+
+	for start := 0; start < len(changes); {
+		end := start + chunkLen(changes[start:])
+		c, err := r.submit(ctx, changes[start:end])
+		if err != nil && end-start > 1 {
+			log.Printf("DDNS: route53 rejected batch of %d changes, retrying individually: %v", end-start, err)
+			for i := start; i < end; i++ {
+				c, err := r.submit(ctx, changes[i:i+1])
+				results[index[i]] = BatchResult{Change: c, Err: err}
+			}
+		} else {
+			for i := start; i < end; i++ {
+				results[index[i]] = BatchResult{Change: c, Err: err}
+			}
+		}
+		start = end
+	}
+	return results
+}
+
+// chunkLen returns how many of changes fit in one request.
+func chunkLen(changes []change) int {
+	records, chars := 0, 0
+	for i, c := range changes {
+		weight := 1
+		if c.Action == "UPSERT" {
+			weight = 2
+		}
+		records += weight * len(c.ResourceRecordSet.ResourceRecords)
+		for _, rr := range c.ResourceRecordSet.ResourceRecords {
+			chars += weight * len(rr.Value)
+		}
+		if i > 0 && (records > route53MaxRecords || chars > route53MaxValueChars) {
+			return i
+		}
+	}
+	return len(changes)
+}
+
+// Get implements Provider.Get for Route53 using ListResourceRecordSets.
+func (r *Route53) Get(ctx context.Context, name, recordType string) (Record, error) {
+	name = fqdn(name)
+	rec := Record{Name: name, Type: recordType}
+
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("type", recordType)
+	query.Set("maxitems", "1")
+
+	var resp listResourceRecordSetsResponse
+	path := "/2013-04-01/hostedzone/" + r.zoneID + "/rrset?" + encodeQuery(query)
+	if err := r.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return rec, err
 	}
+
+	// The listing starts at the requested name and type, but returns the
+	// next record set in order if there is no exact match.
+	for _, rrset := range resp.ResourceRecordSets {
+		if !strings.EqualFold(unescapeName(rrset.Name), name) || rrset.Type != recordType {
+			continue
+		}
+		rec.TTL = rrset.TTL
+		for _, rr := range rrset.ResourceRecords {
+			rec.Values = append(rec.Values, rr.Value)
+		}
+		break
+	}
+	return rec, nil
 }
 
-// Update implements Provider.Update for Route53.
-func (r *Route53) Update(domain, ip string, ttl int) error {
-	// Determine record type based on IP format
-	recordType := "A"
-	if strings.Contains(ip, ":") {
-		recordType = "AAAA"
+// WaitForSync implements SyncWaiter by polling GetChange until the change
+// is INSYNC or the timeout elapses.
+func (r *Route53) WaitForSync(ctx context.Context, c Change, timeout time.Duration) (Change, error) {
+	deadline := time.Now().Add(timeout)
+	for c.Status != ChangeStatusInSync {
+		wait := min(r.pollInterval, time.Until(deadline))
+		if wait <= 0 {
+			return c, fmt.Errorf("change %s still %s after %s", c.ID, c.Status, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return c, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		var resp changeInfoResponse
+		if err := r.do(ctx, http.MethodGet, "/2013-04-01/change/"+c.ID, nil, &resp); err != nil {
+			return c, fmt.Errorf("getting change %s: %w", c.ID, err)
+		}
+		c = resp.ChangeInfo.toChange()
 	}
+	return c, nil
+}
 
-	// Build the XML payload for UPSERT
-	body, err := r.buildChangeXML(domain, ip, recordType, ttl)
+// submit sends changes as one ChangeResourceRecordSets request.
+func (r *Route53) submit(ctx context.Context, changes []change) (Change, error) {
+	body, err := xml.Marshal(changeRequest{
+		XMLNS:       "https://route53.amazonaws.com/doc/2013-04-01/",
+		ChangeBatch: changeBatch{Changes: changes},
+	})
 	if err != nil {
-		return fmt.Errorf("building XML: %w", err)
+		return Change{}, fmt.Errorf("building XML: %w", err)
+	}
+
+	var resp changeInfoResponse
+	if err := r.do(ctx, http.MethodPost, "/2013-04-01/hostedzone/"+r.zoneID+"/rrset", body, &resp); err != nil {
+		return Change{}, err
+	}
+	return resp.ChangeInfo.toChange(), nil
+}
+
+// do sends a signed request to the Route53 API and decodes the XML response
+// into out.
+func (r *Route53) do(ctx context.Context, method, path string, body []byte, out any) error {
+	creds, err := r.creds.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving credentials: %w", err)
 	}
 
 	// Build the request
-	url := fmt.Sprintf("https://%s/2013-04-01/hostedzone/%s/rrset", route53Host, r.zoneID)
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, method, r.endpoint+path, bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("creating request: %w", err)
 	}
 
 	// Sign the request with AWS v4 signature
-	r.signRequest(req, body)
+	signRequest(req, body, creds)
 
 	// Execute request
 	resp, err := r.client.Do(req)
@@ -91,50 +274,69 @@ func (r *Route53) Update(domain, ip string, ttl int) error {
 	}
 	defer resp.Body.Close()
 
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
 	if resp.StatusCode >= 300 {
-		respBody, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("route53 returned %d: %s", resp.StatusCode, string(respBody))
 	}
 
+	if err := xml.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
 	return nil
 }
 
-func (r *Route53) buildChangeXML(domain, ip, recordType string, ttl int) ([]byte, error) {
-	// Ensure domain ends with a dot (FQDN)
-	if !strings.HasSuffix(domain, ".") {
-		domain = domain + "."
-	}
-
-	req := changeRequest{
-		XMLNS: "https://route53.amazonaws.com/doc/2013-04-01/",
-		ChangeBatch: changeBatch{
-			Changes: []change{{
-				Action: "UPSERT",
-				ResourceRecordSet: resourceRecordSet{
-					Name: domain,
-					Type: recordType,
-					TTL:  ttl,
-					ResourceRecords: []resourceRecord{{
-						Value: ip,
-					}},
-				},
-			}},
+func newChange(action string, rec Record) change {
+	records := make([]resourceRecord, len(rec.Values))
+	for i, v := range rec.Values {
+		records[i] = resourceRecord{Value: v}
+	}
+
+	return change{
+		Action: action,
+		ResourceRecordSet: resourceRecordSet{
+			Name:            fqdn(rec.Name),
+			Type:            rec.Type,
+			TTL:             rec.TTL,
+			ResourceRecords: records,
 		},
 	}
+}
+
+// fqdn ensures domain ends with a dot.
+func fqdn(domain string) string {
+	if !strings.HasSuffix(domain, ".") {
+		return domain + "."
+	}
+	return domain
+}
 
-	return xml.Marshal(req)
+// unescapeName reverses Route53's octal escaping of "*" in returned names.
+func unescapeName(name string) string {
+	return strings.ReplaceAll(name, `\052`, "*")
+}
+
+// encodeQuery encodes query parameters in the canonical form AWS signs:
+// sorted by key, with spaces as %20.
+func encodeQuery(v url.Values) string {
+	return strings.ReplaceAll(v.Encode(), "+", "%20")
 }
 
 // signRequest adds AWS v4 signature headers to the request.
-func (r *Route53) signRequest(req *http.Request, payload []byte) {
+func signRequest(req *http.Request, payload []byte, creds Credentials) {
 	now := time.Now().UTC()
 	dateStamp := now.Format("20060102")
 	amzDate := now.Format("20060102T150405Z")
 
 	// Required headers
 	req.Header.Set("Content-Type", "text/xml")
-	req.Header.Set("Host", route53Host)
+	req.Header.Set("Host", req.URL.Host)
 	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
 
 	// AWS v4 signature calculation
 	service := "route53"
@@ -146,11 +348,15 @@ func (r *Route53) signRequest(req *http.Request, payload []byte) {
 	canonicalHeaders := fmt.Sprintf("content-type:%s\nhost:%s\nx-amz-date:%s\n",
 		req.Header.Get("Content-Type"), req.Header.Get("Host"), amzDate)
 	signedHeaders := "content-type;host;x-amz-date"
+	if creds.SessionToken != "" {
+		canonicalHeaders += fmt.Sprintf("x-amz-security-token:%s\n", creds.SessionToken)
+		signedHeaders += ";x-amz-security-token"
+	}
 
 	canonicalRequest := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s",
 		req.Method,
 		req.URL.Path,
-		"", // query string (empty)
+		req.URL.RawQuery, // already canonical, see encodeQuery
 		canonicalHeaders,
 		signedHeaders,
 		payloadHash,
@@ -166,17 +372,17 @@ func (r *Route53) signRequest(req *http.Request, payload []byte) {
 	)
 
 	// Calculate signature
-	signingKey := r.getSignatureKey(dateStamp, region, service)
+	signingKey := getSignatureKey(creds.SecretAccessKey, dateStamp, region, service)
 	signature := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
 
 	// Add authorization header
 	authHeader := fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
-		algorithm, r.accessKey, credentialScope, signedHeaders, signature)
+		algorithm, creds.AccessKeyID, credentialScope, signedHeaders, signature)
 	req.Header.Set("Authorization", authHeader)
 }
 
-func (r *Route53) getSignatureKey(dateStamp, region, service string) []byte {
-	kDate := hmacSHA256([]byte("AWS4"+r.secretKey), []byte(dateStamp))
+func getSignatureKey(secretKey, dateStamp, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secretKey), []byte(dateStamp))
 	kRegion := hmacSHA256(kDate, []byte(region))
 	kService := hmacSHA256(kRegion, []byte(service))
 	kSigning := hmacSHA256(kService, []byte("aws4_request"))
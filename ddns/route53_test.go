@@ -0,0 +1,164 @@
+package ddns
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const changeInfoXML = `<?xml version="1.0" encoding="UTF-8"?>
+<%s xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+  <ChangeInfo>
+    <Id>/change/C2682N5HXP0BZ4</Id>
+    <Status>%s</Status>
+    <SubmittedAt>2026-01-28T12:34:56.789Z</SubmittedAt>
+  </ChangeInfo>
+</%[1]s>`
+
+var testCreds = StaticCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+
+// newTestRoute53 returns a Route53 provider talking to handler.
+func newTestRoute53(t *testing.T, handler http.HandlerFunc) *Route53 {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	r := NewRoute53(testCreds, "Z3M3LMPEXAMPLE")
+	r.endpoint = srv.URL
+	r.pollInterval = time.Millisecond
+	return r
+}
+
+// verifySignature recomputes the AWS v4 signature of a received request.
+func verifySignature(r *http.Request, body []byte, creds StaticCredentials) error {
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) < 8 {
+		return fmt.Errorf("missing X-Amz-Date")
+	}
+	dateStamp := amzDate[:8]
+	canonicalRequest := fmt.Sprintf("%s\n%s\n%s\ncontent-type:%s\nhost:%s\nx-amz-date:%s\n\ncontent-type;host;x-amz-date\n%s",
+		r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Content-Type"), r.Host, amzDate, sha256Hash(body))
+	scope := dateStamp + "/us-east-1/route53/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hash([]byte(canonicalRequest))
+	signature := hex.EncodeToString(hmacSHA256(getSignatureKey(creds.SecretAccessKey, dateStamp, "us-east-1", "route53"), []byte(stringToSign)))
+
+	want := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=content-type;host;x-amz-date, Signature=%s",
+		creds.AccessKeyID, scope, signature)
+	if got := r.Header.Get("Authorization"); got != want {
+		return fmt.Errorf("Authorization = %q, want %q", got, want)
+	}
+	return nil
+}
+
+func TestRoute53Update(t *testing.T) {
+	r := newTestRoute53(t, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.URL.Path != "/2013-04-01/hostedzone/Z3M3LMPEXAMPLE/rrset" {
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+			http.NotFound(w, req)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		if err := verifySignature(req, body, testCreds); err != nil {
+			t.Error(err)
+		}
+
+		var cr changeRequest
+		if err := xml.Unmarshal(body, &cr); err != nil {
+			t.Fatalf("decoding request: %v", err)
+		}
+		if len(cr.ChangeBatch.Changes) != 1 {
+			t.Fatalf("got %d changes, want 1", len(cr.ChangeBatch.Changes))
+		}
+		c := cr.ChangeBatch.Changes[0]
+		rrset := c.ResourceRecordSet
+		if c.Action != "UPSERT" || rrset.Name != "julia.example.com." || rrset.Type != "A" || rrset.TTL != 300 {
+			t.Errorf("change = %+v", c)
+		}
+		if len(rrset.ResourceRecords) != 1 || rrset.ResourceRecords[0].Value != "203.0.113.50" {
+			t.Errorf("records = %+v", rrset.ResourceRecords)
+		}
+
+		_, _ = fmt.Fprintf(w, changeInfoXML, "ChangeResourceRecordSetsResponse", ChangeStatusPending)
+	})
+
+	c, err := r.Update(context.Background(), Record{Name: "julia.example.com", Type: "A", Values: []string{"203.0.113.50"}, TTL: 300})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	want := Change{ID: "C2682N5HXP0BZ4", Status: ChangeStatusPending, SubmittedAt: time.Date(2026, 1, 28, 12, 34, 56, 789000000, time.UTC)}
+	if c != want {
+		t.Errorf("Update = %+v, want %+v", c, want)
+	}
+}
+
+func TestRoute53UpdateError(t *testing.T) {
+	r := newTestRoute53(t, func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "<ErrorResponse><Error><Code>InvalidChangeBatch</Code></Error></ErrorResponse>", http.StatusBadRequest)
+	})
+
+	_, err := r.Update(context.Background(), Record{Name: "julia.example.com", Type: "A", Values: []string{"203.0.113.50"}})
+	if err == nil || !strings.Contains(err.Error(), "InvalidChangeBatch") {
+		t.Errorf("Update error = %v, want InvalidChangeBatch", err)
+	}
+}
+
+func TestRoute53WaitForSync(t *testing.T) {
+	var mu sync.Mutex
+	polls := 0
+	r := newTestRoute53(t, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || req.URL.Path != "/2013-04-01/change/C2682N5HXP0BZ4" {
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+			http.NotFound(w, req)
+			return
+		}
+		if err := verifySignature(req, nil, testCreds); err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		polls++
+		status := ChangeStatusPending
+		if polls >= 3 {
+			status = ChangeStatusInSync
+		}
+		mu.Unlock()
+		_, _ = fmt.Fprintf(w, changeInfoXML, "GetChangeResponse", status)
+	})
+
+	c, err := r.WaitForSync(context.Background(), Change{ID: "C2682N5HXP0BZ4", Status: ChangeStatusPending}, time.Minute)
+	if err != nil {
+		t.Fatalf("WaitForSync: %v", err)
+	}
+	if c.Status != ChangeStatusInSync {
+		t.Errorf("status = %s, want %s", c.Status, ChangeStatusInSync)
+	}
+	if polls != 3 {
+		t.Errorf("polled %d times, want 3", polls)
+	}
+}
+
+func TestRoute53WaitForSyncTimeout(t *testing.T) {
+	r := newTestRoute53(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintf(w, changeInfoXML, "GetChangeResponse", ChangeStatusPending)
+	})
+
+	start := time.Now()
+	c, err := r.WaitForSync(context.Background(), Change{ID: "C2682N5HXP0BZ4", Status: ChangeStatusPending}, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "still PENDING") {
+		t.Errorf("WaitForSync error = %v, want timeout", err)
+	}
+	if c.Status != ChangeStatusPending {
+		t.Errorf("status = %s, want %s", c.Status, ChangeStatusPending)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("WaitForSync took %s", elapsed)
+	}
+}
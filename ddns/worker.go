@@ -0,0 +1,99 @@
+package ddns
+
+import (
+	"time"
+)
+
+// enqueue records rec as the latest desired state for its type and wakes
+// the entry's worker. A record still pending from an earlier call is
+// replaced, so bursts are coalesced and only the latest value is applied.
+func (d *Dispatcher) enqueue(e *Entry, rec Record) {
+	d.mu.Lock()
+	e.pending[rec.Type] = rec
+	d.mu.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default: // already signalled
+	}
+}
+
+// work serially applies an entry's pending records until the dispatcher is
+// closed. After the first change of a burst it waits Debounce for further
+// changes, and leaves at least MinInterval between provider calls.
+func (d *Dispatcher) work(e *Entry) {
+	defer d.wg.Done()
+
+	var lastCall time.Time
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-e.wake:
+		}
+
+		// Let a burst of changes settle; the latest value wins
+		if !d.sleep(e.Debounce) {
+			return
+		}
+
+		changed := false
+		for {
+			// Records changing while we wait are picked up at their
+			// latest value
+			if !d.sleep(time.Until(lastCall.Add(e.MinInterval))) {
+				return
+			}
+			recs := d.next(e)
+			if len(recs) == 0 {
+				break
+			}
+			called, ok := d.apply(d.ctx, e, recs)
+			if called {
+				lastCall = time.Now()
+			}
+			changed = changed || ok
+		}
+
+		if changed && e.TXT {
+			if !d.sleep(time.Until(lastCall.Add(e.MinInterval))) {
+				return
+			}
+			if called, _ := d.apply(d.ctx, e, []Record{d.txtRecord(e)}); called {
+				lastCall = time.Now()
+			}
+		}
+	}
+}
+
+// next removes and returns the entry's pending records for the next
+// provider call, in a fixed order of record types: all of them if the entry
+// is batched, so A and AAAA changes share a request, otherwise one.
+func (d *Dispatcher) next(e *Entry) []Record {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var recs []Record
+	for _, recordType := range []string{"A", "AAAA", "CNAME"} {
+		if rec, ok := e.pending[recordType]; ok {
+			delete(e.pending, recordType)
+			recs = append(recs, rec)
+			if e.batch == nil {
+				break
+			}
+		}
+	}
+	return recs
+}
+
+// sleep pauses for wait, returning false if the dispatcher is closed meanwhile.
+func (d *Dispatcher) sleep(wait time.Duration) bool {
+	if wait <= 0 {
+		return d.ctx.Err() == nil
+	}
+	select {
+	case <-d.ctx.Done():
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
@@ -0,0 +1,105 @@
+package ddns
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProvider keeps records in memory and records when they were written.
+type fakeProvider struct {
+	mu       sync.Mutex
+	records  map[string][]string // type → values
+	writes   []time.Time
+	failures int // updates left to fail
+}
+
+func (p *fakeProvider) Update(_ context.Context, rec Record) (Change, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return Change{}, errors.New("provider unavailable")
+	}
+	p.records[rec.Type] = rec.Values
+	p.writes = append(p.writes, time.Now())
+	return Change{}, nil
+}
+
+func (p *fakeProvider) Delete(_ context.Context, rec Record) (Change, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.records, rec.Type)
+	p.writes = append(p.writes, time.Now())
+	return Change{}, nil
+}
+
+func (p *fakeProvider) Get(_ context.Context, name, recordType string) (Record, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Record{Name: name, Type: recordType, Values: p.records[recordType]}, nil
+}
+
+func (p *fakeProvider) writeTimes() []time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]time.Time(nil), p.writes...)
+}
+
+// newTestDispatcher returns a dispatcher whose entries all use p.
+func newTestDispatcher(t *testing.T, p Provider, lookup LookupFunc, seen SeenFunc, configs ...Config) *Dispatcher {
+	t.Helper()
+	d := newDispatcher(configs, nil, lookup, seen, func(Config) (Provider, error) { return p, nil })
+	t.Cleanup(d.Close)
+	return d
+}
+
+// juliaConfig is an address entry for "julia".
+func juliaConfig(minInterval time.Duration) Config {
+	return Config{Provider: "fake", IAM: "julia", Domain: "julia.example.com", MinInterval: minInterval}
+}
+
+func waitForWrites(t *testing.T, p *fakeProvider, n int) []time.Time {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if writes := p.writeTimes(); len(writes) >= n {
+			return writes
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("got %d writes, want %d", len(p.writeTimes()), n)
+	return nil
+}
+
+func TestMinIntervalBetweenRecordTypes(t *testing.T) {
+	const minInterval = 100 * time.Millisecond
+	p := &fakeProvider{records: make(map[string][]string)}
+	d := newTestDispatcher(t, p, nil, nil, juliaConfig(minInterval))
+
+	d.TriggerUpdate("julia", "203.0.113.50")
+	d.TriggerUpdate("julia", "2001:db8::1")
+
+	writes := waitForWrites(t, p, 2)
+	if gap := writes[1].Sub(writes[0]); gap < minInterval {
+		t.Errorf("A and AAAA written %s apart, want at least %s", gap, minInterval)
+	}
+}
+
+func TestMinIntervalSkipsLiveRecords(t *testing.T) {
+	const minInterval = time.Hour
+	p := &fakeProvider{records: map[string][]string{"A": {"203.0.113.50"}}}
+	d := newTestDispatcher(t, p, nil, nil, juliaConfig(minInterval))
+
+	// Already in DNS, so this must not use up the interval
+	d.TriggerUpdate("julia", "203.0.113.50")
+	time.Sleep(50 * time.Millisecond)
+	d.TriggerUpdate("julia", "203.0.113.51")
+
+	waitForWrites(t, p, 1)
+	if rec, _ := p.Get(context.Background(), "julia.example.com", "A"); len(rec.Values) != 1 || rec.Values[0] != "203.0.113.51" {
+		t.Errorf("A = %v, want 203.0.113.51", rec.Values)
+	}
+}
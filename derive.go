@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/netip"
+
+	"github.com/tracyhatemice/who/ddns"
+	"github.com/tracyhatemice/who/event"
+)
+
+// derivedName is a name whose IPv6 address combines the delegated prefix of
+// another name, such as a router, with a fixed interface ID.
+type derivedName struct {
+	name         string
+	suffix       netip.Addr
+	prefixLength int
+}
+
+// newDerivedName validates a who entry with prefix_from set.
+func newDerivedName(entry WhoEntry) (derivedName, error) {
+	if entry.IPv6Suffix == "" {
+		return derivedName{}, errors.New("prefix_from requires ipv6_suffix")
+	}
+	suffix, err := ddns.ParseSuffix(entry.IPv6Suffix)
+	if err != nil {
+		return derivedName{}, err
+	}
+	prefixLength := entry.PrefixLength
+	if prefixLength < 0 || prefixLength > 128 {
+		return derivedName{}, errors.New("prefix_length must be between 0 and 128 (0 means 64)")
+	}
+	if prefixLength == 0 {
+		prefixLength = 64
+	}
+	return derivedName{name: entry.IAM, suffix: suffix, prefixLength: prefixLength}, nil
+}
+
+// address returns the derived address for the source's IP, or false if the
+// IP isn't an IPv6 address.
+func (d derivedName) address(ip string) (string, bool) {
+	return ddns.DeriveAddress(ip, d.prefixLength, d.suffix)
+}
+
+// isDerived reports whether name is derived from another name's prefix.
+func (s *Server) isDerived(name string) bool {
+	for _, names := range s.derived {
+		for _, d := range names {
+			if d.name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deriveNames updates every name derived from source's prefix after source
+// checked in with ip, and notifies subscribers of the ones that changed.
+func (s *Server) deriveNames(source, ip string) {
+	for _, d := range s.derived[source] {
+		addr, ok := d.address(ip)
+		if !ok {
+			continue // only IPv6 check-ins carry the prefix
+		}
+		previous, version := s.store.Set(d.name, addr)
+		if version != 0 {
+			log.Printf("WHO: %s -> %s (derived from %s)", d.name, addr, source)
+			s.bus.Publish(event.Event{Kind: event.Change, Name: d.name, Old: previous, New: addr, Source: event.SourceDerived, Seq: version})
+		}
+		if groups := s.failover.GroupsOf(d.name); len(groups) > 0 {
+			s.checkFailover(groups...)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tracyhatemice/who/api"
+	"github.com/tracyhatemice/who/event"
+)
+
+// maxDumpSize limits the body of POST /admin/import.
+const maxDumpSize = 64 << 20
+
+// adminExportHandler returns every stored name and the history of every
+// name as a versioned dump.
+func (s *Server) adminExportHandler(w http.ResponseWriter, _ *http.Request) {
+	dump := api.Dump{
+		Version:  api.DumpVersion,
+		Exported: time.Now().UTC(),
+		Names:    []api.DumpName{},
+		History:  s.history.All(),
+	}
+	for name := range s.store.All() {
+		// Names that haven't checked in since they were pre-loaded come
+		// from the config, which moves along with the dump
+		if ip, seen, ok := s.store.Seen(name); ok && !seen.IsZero() {
+			dump.Names = append(dump.Names, api.DumpName{Name: name, IP: ip, Seen: seen.UTC()})
+		}
+	}
+	slices.SortFunc(dump.Names, func(a, b api.DumpName) int { return strings.Compare(a.Name, b.Name) })
+
+	w.Header().Set("Content-Disposition", `attachment; filename="who.json"`)
+	writeJSON(w, http.StatusOK, dump)
+}
+
+// adminImportHandler restores a dump. With ?mode=replay, changed names are
+// published like check-ins, so DDNS records, webhooks and other subscribers
+// catch up; with ?mode=silent (the default) only the store is restored.
+// Names missing from the dump are kept.
+func (s *Server) adminImportHandler(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	switch mode {
+	case "":
+		mode = api.ImportSilent
+	case api.ImportReplay, api.ImportSilent:
+	default:
+		http.Error(w, "mode must be replay or silent", http.StatusBadRequest)
+		return
+	}
+
+	var dump api.Dump
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDumpSize)).Decode(&dump); err != nil {
+		http.Error(w, "invalid dump: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if dump.Version != api.DumpVersion {
+		http.Error(w, "unsupported dump version", http.StatusBadRequest)
+		return
+	}
+	for _, n := range dump.Names {
+		if _, err := netip.ParseAddr(n.IP); err != nil || n.Name == "" {
+			http.Error(w, "invalid name "+n.Name+" in dump", http.StatusBadRequest)
+			return
+		}
+		if n.Seen.IsZero() {
+			http.Error(w, "missing seen for "+n.Name+" in dump", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result := api.ImportResult{}
+	persisted := make(map[string]bool) // names to write back to the config file
+	for _, n := range dump.Names {
+		// Aliases and failover groups aren't stored, and derived names
+		// follow the name they are derived from
+		if s.readOnly(n.Name) != "" {
+			result.Skipped = append(result.Skipped, n.Name)
+			continue
+		}
+		result.Imported++
+		if s.restore(n.Name, n.IP, n.Seen, mode == api.ImportReplay, persisted) {
+			result.Changed++
+		}
+	}
+	if len(persisted) > 0 {
+		// Write the stored IPs, which check-ins during the import may have
+		// changed again
+		ips := make(map[string]string, len(persisted))
+		for name := range persisted {
+			if ip, ok := s.store.Get(name); ok {
+				ips[name] = ip
+			}
+		}
+		s.saveWhoIPs(ips)
+	}
+	for name, events := range dump.History {
+		s.history.Restore(name, events)
+	}
+	log.Printf("WHO: imported %d names (%d changed, %d skipped, %s)", result.Imported, result.Changed, len(result.Skipped), mode)
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// restore stores an imported name and updates the failover groups and
+// derived names depending on it. With replay, changes are published like
+// check-ins; otherwise changed names from the who config are added to
+// persisted, to be written back to the config file so they survive a
+// restart. It reports whether the name's IP changed.
+func (s *Server) restore(name, ip string, seen time.Time, replay bool, persisted map[string]bool) bool {
+	previous, version := s.store.Restore(name, ip, seen)
+	switch {
+	case version == 0 && previous != ip:
+		return false // checked in since the export, which is kept
+	case version == 0:
+	case replay:
+		s.bus.Publish(event.Event{Kind: event.Change, Name: name, Old: previous, New: ip, Source: event.SourceImport, Seq: version})
+	case s.persists(name):
+		persisted[name] = true
+	}
+
+	if groups := s.failover.GroupsOf(name); len(groups) > 0 {
+		if replay {
+			s.checkFailover(groups...)
+		} else {
+			s.failover.Check(groups...)
+		}
+	}
+
+	for _, d := range s.derived[name] {
+		if addr, ok := d.address(ip); ok {
+			s.restore(d.name, addr, seen, replay, persisted)
+		}
+	}
+	return version != 0
+}
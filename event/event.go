@@ -0,0 +1,151 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// Kind is the type of an event.
+type Kind string
+
+// Event kinds.
+const (
+	Change  Kind = "change"  // a name's IP changed, or a failover group switched
+	Expire  Kind = "expire"  // a name expired
+	Offline Kind = "offline" // no member of a failover group is available
+	Delete  Kind = "delete"  // a name was removed via the admin API
+)
+
+// Sources of events.
+const (
+	SourceIAM      = "iam"
+	SourceExpire   = "expire"
+	SourceFailover = "failover"
+	SourceDerived  = "derived"
+	SourceAdmin    = "admin"
+	SourceImport   = "import"
+)
+
+// Event describes a change of a name's address.
+type Event struct {
+	Kind   Kind
+	Name   string
+	Old    string // previous IP, empty if the name is new
+	New    string // current IP, empty if the name was removed
+	Source string
+	Time   time.Time
+	Seq    uint64 // orders changes of the same name, zero if unordered
+}
+
+// Subscriber receives events from a Bus.
+type Subscriber interface {
+	Handle(Event)
+}
+
+// SubscriberFunc adapts a function to a Subscriber.
+type SubscriberFunc func(Event)
+
+// Handle implements Subscriber.
+func (f SubscriberFunc) Handle(e Event) { f(e) }
+
+// Bus delivers events to subscribers. Every subscriber receives every
+// event, in publish order, on its own goroutine, so a slow subscriber
+// doesn't hold up the others or the publisher.
+//
+// Changes are made and published in separate steps, so two concurrent
+// changes of a name may be published out of order. An event whose Seq is
+// older than one already published for its name is therefore dropped.
+type Bus struct {
+	mu     sync.Mutex
+	subs   []*subscription
+	seqs   map[string]uint64 // name → Seq of its last published event
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// subscription is a subscriber and its queue of undelivered events.
+type subscription struct {
+	sub    Subscriber
+	mu     sync.Mutex
+	queue  []Event
+	closed bool
+	wake   chan struct{}
+}
+
+// NewBus creates an empty Bus.
+func NewBus() *Bus {
+	return &Bus{seqs: make(map[string]uint64)}
+}
+
+// Subscribe registers s for all events published afterwards.
+func (b *Bus) Subscribe(s Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &subscription{sub: s, wake: make(chan struct{}, 1)}
+	b.subs = append(b.subs, sub)
+	b.wg.Go(sub.run)
+}
+
+// Publish queues e for every subscriber. Events published after Close or
+// superseded by a later change of the same name are dropped.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	if e.Seq != 0 {
+		if e.Seq <= b.seqs[e.Name] {
+			return
+		}
+		b.seqs[e.Name] = e.Seq
+	}
+	for _, sub := range b.subs {
+		sub.mu.Lock()
+		sub.queue = append(sub.queue, e)
+		sub.mu.Unlock()
+		select {
+		case sub.wake <- struct{}{}:
+		default: // already signalled
+		}
+	}
+}
+
+// Close stops accepting events and waits until every queued event has
+// been delivered.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	b.closed = true
+	for _, sub := range b.subs {
+		sub.mu.Lock()
+		sub.closed = true
+		sub.mu.Unlock()
+		select {
+		case sub.wake <- struct{}{}:
+		default:
+		}
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// run delivers queued events until the bus is closed and the queue is empty.
+func (s *subscription) run() {
+	for range s.wake {
+		s.mu.Lock()
+		queue, closed := s.queue, s.closed
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, e := range queue {
+			s.sub.Handle(e)
+		}
+		if closed {
+			return
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package event
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBusDropsSupersededEvents(t *testing.T) {
+	b := NewBus()
+	var got []string
+	b.Subscribe(SubscriberFunc(func(e Event) { got = append(got, e.Name+" "+e.New) }))
+
+	// B was stored after A but published first, so A is stale
+	b.Publish(Event{Kind: Change, Name: "nas", New: "192.168.1.11", Seq: 2})
+	b.Publish(Event{Kind: Change, Name: "nas", New: "192.168.1.10", Seq: 1})
+	// Sequences are per name, and unordered events are always delivered
+	b.Publish(Event{Kind: Change, Name: "laptop", New: "10.0.0.5", Seq: 1})
+	b.Publish(Event{Kind: Change, Name: "nas", New: "192.168.1.12"})
+	b.Close()
+
+	want := []string{"nas 192.168.1.11", "laptop 10.0.0.5", "nas 192.168.1.12"}
+	if !slices.Equal(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+}
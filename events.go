@@ -0,0 +1,177 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tracyhatemice/who/api"
+	"github.com/tracyhatemice/who/event"
+)
+
+const (
+	eventBufferSize    = 1000 // events kept for Last-Event-ID resume
+	subscriberBuffer   = 64   // events queued per client before it's dropped
+	heartbeatInterval  = 15 * time.Second
+	eventRetryInterval = 3 * time.Second
+)
+
+// eventStream keeps recent events and fans them out to subscribers.
+type eventStream struct {
+	mu     sync.Mutex
+	nextID uint64
+	buffer []api.Event
+	subs   map[chan api.Event]struct{}
+	closed bool
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{nextID: 1, subs: make(map[chan api.Event]struct{})}
+}
+
+// Handle implements event.Subscriber: it assigns the next ID to an event
+// and sends it to every client. Clients that can't keep up are dropped;
+// they resume with Last-Event-ID.
+func (es *eventStream) Handle(ev event.Event) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	e := api.NewEvent(ev)
+	e.ID = es.nextID
+	es.nextID++
+	es.buffer = append(es.buffer, e)
+	if len(es.buffer) > eventBufferSize {
+		es.buffer = slices.Delete(es.buffer, 0, len(es.buffer)-eventBufferSize)
+	}
+
+	for ch := range es.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(es.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the buffered events after lastID and a channel of new
+// events. The channel is closed when the subscriber is dropped or the
+// stream is closed.
+func (es *eventStream) Subscribe(lastID uint64) ([]api.Event, chan api.Event) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	ch := make(chan api.Event, subscriberBuffer)
+	if es.closed {
+		close(ch)
+		return nil, ch
+	}
+	es.subs[ch] = struct{}{}
+
+	// An ID from before a restart can't be resumed, replay the buffer instead
+	if lastID >= es.nextID {
+		lastID = 0
+	}
+	i, _ := slices.BinarySearchFunc(es.buffer, lastID+1, func(e api.Event, id uint64) int {
+		return cmp.Compare(e.ID, id)
+	})
+	return slices.Clone(es.buffer[i:]), ch
+}
+
+// Unsubscribe stops sending events to ch.
+func (es *eventStream) Unsubscribe(ch chan api.Event) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if _, ok := es.subs[ch]; ok {
+		delete(es.subs, ch)
+		close(ch)
+	}
+}
+
+// Close ends every subscription, so streaming handlers return on shutdown.
+func (es *eventStream) Close() {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.closed = true
+	for ch := range es.subs {
+		delete(es.subs, ch)
+		close(ch)
+	}
+}
+
+// eventsHandler streams events as Server-Sent Events, for every name or,
+// with {name}, for one name, failover group or the members of an alias.
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	var lastID uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		lastID, _ = strconv.ParseUint(v, 10, 64)
+	}
+
+	var names []string
+	if name := r.PathValue("name"); name != "" {
+		names = append([]string{name}, s.aliases[name]...)
+	}
+	wanted := func(e api.Event) bool {
+		return len(names) == 0 || slices.Contains(names, e.IAM)
+	}
+
+	backlog, ch := s.events.Subscribe(lastID)
+	defer s.events.Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx response buffering
+	_, _ = fmt.Fprintf(w, "retry: %d\n\n", eventRetryInterval.Milliseconds())
+
+	write := func(e api.Event) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Kind, data)
+		return err
+	}
+	for _, e := range backlog {
+		if wanted(e) {
+			if err := write(e); err != nil {
+				return
+			}
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !wanted(e) {
+				continue
+			}
+			if err := write(e); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracyhatemice/who/api"
+	"github.com/tracyhatemice/who/event"
+)
+
+// newEventsServer serves the event stream of a server with the given aliases.
+func newEventsServer(t *testing.T, aliases map[string][]string) (*eventStream, *httptest.Server) {
+	t.Helper()
+	s := &Server{events: newEventStream(), aliases: aliases}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", s.eventsHandler)
+	mux.HandleFunc("GET /events/{name}", s.eventsHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Cleanup(s.events.Close) // ends the streams, so srv.Close doesn't wait on them
+	return s.events, srv
+}
+
+// publish sends a change of name to ip to the stream.
+func publish(es *eventStream, name, ip string) {
+	es.Handle(event.Event{Kind: event.Change, Name: name, New: ip, Time: time.Now()})
+}
+
+// stream is a client of the event stream.
+type stream struct {
+	events chan api.Event
+}
+
+// subscribe connects to path, sending lastID as Last-Event-ID if set.
+func subscribe(t *testing.T, srv *httptest.Server, path, lastID string) *stream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	s := &stream{events: make(chan api.Event, eventBufferSize+16)}
+	go func() {
+		defer resp.Body.Close()
+		defer close(s.events)
+		scanner := bufio.NewScanner(resp.Body)
+		var id uint64
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				id, _ = strconv.ParseUint(value, 10, 64)
+			case "data":
+				var e api.Event
+				if err := json.Unmarshal([]byte(value), &e); err != nil || e.ID != id {
+					t.Errorf("event %d: %s (%v)", id, value, err)
+				}
+				s.events <- e
+			}
+		}
+	}()
+	return s
+}
+
+// expect fails unless the next events are the changes given as "id name ip".
+func (s *stream) expect(t *testing.T, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case e, ok := <-s.events:
+			if !ok {
+				t.Fatalf("stream ended, want %s", w)
+			}
+			if got := fmt.Sprintf("%d %s %s", e.ID, e.IAM, e.IP); got != w {
+				t.Fatalf("event %q, want %q", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event, want %s", w)
+		}
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	es, srv := newEventsServer(t, nil)
+	for i := 1; i <= 5; i++ {
+		publish(es, "nas", fmt.Sprintf("192.168.1.%d", i))
+	}
+
+	// Events after Last-Event-ID are replayed, then new ones follow
+	s := subscribe(t, srv, "/events", "3")
+	s.expect(t, "4 nas 192.168.1.4", "5 nas 192.168.1.5")
+	publish(es, "laptop", "10.0.0.5")
+	s.expect(t, "6 laptop 10.0.0.5")
+
+	// Without Last-Event-ID, the whole buffer is replayed
+	s = subscribe(t, srv, "/events", "")
+	s.expect(t, "1 nas 192.168.1.1")
+}
+
+func TestEventsResumeAfterRestart(t *testing.T) {
+	es, srv := newEventsServer(t, nil)
+	publish(es, "nas", "192.168.1.1")
+	publish(es, "nas", "192.168.1.2")
+
+	// An ID the server hasn't handed out yet comes from before a restart
+	s := subscribe(t, srv, "/events", "41")
+	s.expect(t, "1 nas 192.168.1.1", "2 nas 192.168.1.2")
+
+	s = subscribe(t, srv, "/events", "not a number")
+	s.expect(t, "1 nas 192.168.1.1")
+}
+
+func TestEventsResumeTrimmedBuffer(t *testing.T) {
+	es, srv := newEventsServer(t, nil)
+	for i := range eventBufferSize + 5 {
+		publish(es, "nas", fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+
+	// Events older than the buffer are lost; the stream starts at its oldest
+	s := subscribe(t, srv, "/events", "2")
+	s.expect(t, "6 nas 10.0.0.5")
+
+	s = subscribe(t, srv, "/events", strconv.Itoa(eventBufferSize+3))
+	s.expect(t, fmt.Sprintf("%d nas 10.0.3.235", eventBufferSize+4))
+}
+
+func TestEventsOfName(t *testing.T) {
+	es, srv := newEventsServer(t, map[string][]string{"home": {"nas", "laptop"}})
+	publish(es, "nas", "192.168.1.10")
+	publish(es, "phone", "10.0.0.7")
+	publish(es, "laptop", "10.0.0.5")
+
+	// An alias streams the events of its members
+	s := subscribe(t, srv, "/events/home", "")
+	s.expect(t, "1 nas 192.168.1.10", "3 laptop 10.0.0.5")
+
+	s = subscribe(t, srv, "/events/phone", "1")
+	publish(es, "nas", "192.168.1.11")
+	publish(es, "phone", "10.0.0.8")
+	s.expect(t, "2 phone 10.0.0.7", "5 phone 10.0.0.8")
+}
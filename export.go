@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tracyhatemice/who/ddns"
+)
+
+// allowlist is the widened, deduplicated networks of a name split by family.
+type allowlist struct {
+	name string // base name for sets and matchers
+	v4   []netip.Prefix
+	v6   []netip.Prefix
+}
+
+// families returns the non-filtered address families in output order.
+func (a allowlist) families() []allowlistFamily {
+	var families []allowlistFamily
+	if a.v4 != nil {
+		families = append(families, allowlistFamily{suffix: "_v4", nftType: "ipv4_addr", ipsetFamily: "inet", prefixes: a.v4})
+	}
+	if a.v6 != nil {
+		families = append(families, allowlistFamily{suffix: "_v6", nftType: "ipv6_addr", ipsetFamily: "inet6", prefixes: a.v6})
+	}
+	return families
+}
+
+type allowlistFamily struct {
+	suffix      string
+	nftType     string
+	ipsetFamily string
+	prefixes    []netip.Prefix
+}
+
+// all returns the prefixes of both families, IPv4 first.
+func (a allowlist) all() []netip.Prefix {
+	return append(slices.Clone(a.v4), a.v6...)
+}
+
+// allowlistFormats renders an allowlist in each supported export format.
+var allowlistFormats = map[string]func(io.Writer, allowlist){
+	"cidr":     writeCIDR,
+	"nginx":    writeNginx,
+	"nftables": writeNftables,
+	"ipset":    writeIPSet,
+	"caddy":    writeCaddy,
+}
+
+func writeCIDR(w io.Writer, a allowlist) {
+	for _, p := range a.all() {
+		_, _ = fmt.Fprintln(w, p)
+	}
+}
+
+func writeNginx(w io.Writer, a allowlist) {
+	for _, p := range a.all() {
+		_, _ = fmt.Fprintf(w, "allow %s;\n", p)
+	}
+}
+
+func writeNftables(w io.Writer, a allowlist) {
+	for _, f := range a.families() {
+		_, _ = fmt.Fprintf(w, "set %s%s {\n", a.name, f.suffix)
+		_, _ = fmt.Fprintf(w, "\ttype %s\n", f.nftType)
+		_, _ = fmt.Fprintln(w, "\tflags interval")
+		// nft rejects an empty element list
+		if len(f.prefixes) > 0 {
+			_, _ = fmt.Fprintf(w, "\telements = { %s }\n", joinPrefixes(f.prefixes, ", "))
+		}
+		_, _ = fmt.Fprintln(w, "}")
+	}
+}
+
+// ipsetMaxName is the longest set name ipset accepts.
+const ipsetMaxName = 31
+
+func writeIPSet(w io.Writer, a allowlist) {
+	for _, f := range a.families() {
+		set := a.name[:min(len(a.name), ipsetMaxName-len(f.suffix))] + f.suffix
+		_, _ = fmt.Fprintf(w, "create %s hash:net family %s -exist\n", set, f.ipsetFamily)
+		_, _ = fmt.Fprintf(w, "flush %s\n", set)
+		for _, p := range f.prefixes {
+			_, _ = fmt.Fprintf(w, "add %s %s\n", set, p)
+		}
+	}
+}
+
+func writeCaddy(w io.Writer, a allowlist) {
+	prefixes := a.all()
+	if len(prefixes) == 0 {
+		// Caddy rejects remote_ip without ranges, so match nothing instead
+		_, _ = fmt.Fprintf(w, "@%s not remote_ip 0.0.0.0/0 ::/0\n", a.name)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "@%s remote_ip %s\n", a.name, joinPrefixes(prefixes, " "))
+}
+
+func joinPrefixes(prefixes []netip.Prefix, sep string) string {
+	s := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		s[i] = p.String()
+	}
+	return strings.Join(s, sep)
+}
+
+// setNameChars matches characters not allowed in nftables/ipset set names.
+var setNameChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// exportHandler renders the current IPs of a name, alias or failover group
+// as an allowlist in the requested format.
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = "cidr"
+	}
+	write, ok := allowlistFormats[format]
+	if !ok {
+		http.Error(w, "format must be one of cidr, nginx, nftables, ipset, caddy", http.StatusBadRequest)
+		return
+	}
+
+	widen, err := parseWidening(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !slices.Contains(s.names(), name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	set := query.Get("set")
+	if set == "" {
+		set = "who_" + name
+	}
+	a := allowlist{name: setNameChars.ReplaceAllString(set, "_")}
+	switch query.Get("family") {
+	case "":
+		a.v4, a.v6 = []netip.Prefix{}, []netip.Prefix{}
+	case "ipv4":
+		a.v4 = []netip.Prefix{}
+	case "ipv6":
+		a.v6 = []netip.Prefix{}
+	default:
+		http.Error(w, "family must be ipv4 or ipv6", http.StatusBadRequest)
+		return
+	}
+
+	for _, ip := range s.resolve(name) {
+		prefix, ok := widen.prefix(ip)
+		switch {
+		case !ok:
+		case prefix.Addr().Is4() && a.v4 != nil:
+			a.v4 = append(a.v4, prefix)
+		case prefix.Addr().Is6() && a.v6 != nil:
+			a.v6 = append(a.v6, prefix)
+		}
+	}
+	for _, prefixes := range []*[]netip.Prefix{&a.v4, &a.v6} {
+		slices.SortFunc(*prefixes, func(a, b netip.Prefix) int {
+			return cmp.Or(a.Addr().Compare(b.Addr()), cmp.Compare(a.Bits(), b.Bits()))
+		})
+		*prefixes = slices.Compact(*prefixes)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	write(w, a)
+}
+
+// hostname matches names that are valid in hosts and zone files.
+var hostname = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?(\.[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?)*$`)
+
+// hostsHandler renders every name, alias and failover group in /etc/hosts
+// format, optionally qualified with a domain suffix.
+func (s *Server) hostsHandler(w http.ResponseWriter, r *http.Request) {
+	domain := strings.Trim(r.URL.Query().Get("domain"), ".")
+	if domain != "" && !hostname.MatchString(domain) {
+		http.Error(w, "invalid domain", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, name := range s.names() {
+		if !hostname.MatchString(name) {
+			continue
+		}
+		names := name
+		if domain != "" {
+			names = name + "." + domain + " " + name
+		}
+		for _, ip := range s.resolve(name) {
+			_, _ = fmt.Fprintf(w, "%s\t%s\n", ip, names)
+		}
+	}
+}
+
+// zoneHandler renders every name, alias and failover group as a BIND zone
+// file. The SOA serial increases whenever the records change.
+func (s *Server) zoneHandler(w http.ResponseWriter, r *http.Request) {
+	zone := strings.Trim(r.PathValue("zone"), ".")
+	if !hostname.MatchString(zone) {
+		http.Error(w, "invalid zone", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	ttl := 300
+	if v := query.Get("ttl"); v != "" {
+		var err error
+		if ttl, err = strconv.Atoi(v); err != nil || ttl < 0 {
+			http.Error(w, "ttl must be a non-negative number of seconds", http.StatusBadRequest)
+			return
+		}
+	}
+	ns := strings.TrimSuffix(cmp.Or(query.Get("ns"), "ns."+zone), ".")
+	mbox := strings.TrimSuffix(cmp.Or(query.Get("mbox"), "hostmaster."+zone), ".")
+	if !hostname.MatchString(ns) || !hostname.MatchString(mbox) {
+		http.Error(w, "invalid ns or mbox", http.StatusBadRequest)
+		return
+	}
+
+	var records bytes.Buffer
+	for _, name := range s.names() {
+		if !hostname.MatchString(name) {
+			continue
+		}
+		for _, ip := range s.resolve(name) {
+			_, _ = fmt.Fprintf(&records, "%s\tIN\t%s\t%s\n", name, ddns.RecordType(ip), ip)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintf(w, "$ORIGIN %s.\n$TTL %d\n", zone, ttl)
+	_, _ = fmt.Fprintf(w, "@\tIN\tSOA\t%s. %s. %d 3600 600 604800 %d\n", ns, mbox, s.zoneSerial(records.Bytes()), ttl)
+	_, _ = fmt.Fprintf(w, "@\tIN\tNS\t%s.\n", ns)
+	_, _ = w.Write(records.Bytes())
+}
+
+// zoneSerial returns the SOA serial for the given zone records, bumping it
+// if they differ from the records it was last bumped for. A bumped serial
+// is at least the current Unix time and is written back to the config
+// file, so it never goes backwards, not even across restarts.
+func (s *Server) zoneSerial(records []byte) int64 {
+	sum := sha256.Sum256(records)
+	hash := hex.EncodeToString(sum[:16])
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	if s.config.Zone == nil {
+		s.config.Zone = &ZoneEntry{}
+	}
+	z := s.config.Zone
+	if z.Hash == hash {
+		return z.Serial
+	}
+	z.Serial = max(z.Serial+1, time.Now().Unix())
+	z.Hash = hash
+	if s.configPath != "" {
+		if err := SaveConfig(s.configPath, s.config); err != nil {
+			log.Printf("WHO: failed to save zone serial %d to config: %v", z.Serial, err)
+		}
+	}
+	return z.Serial
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Failover resolves failover group names to the IP of their active member:
+// the first member, in priority order, that has checked in recently.
+type Failover struct {
+	store    *Store
+	mu       sync.Mutex
+	groups   map[string]*failoverGroup
+	groupsOf map[string][]string // member name -> group names
+}
+
+// failoverGroup is an ordered list of members and the currently active one.
+type failoverGroup struct {
+	members    []string
+	staleAfter time.Duration // 0 means members never go stale
+	active     string        // empty if no member is available
+	ip         string
+}
+
+// FailoverSwitch describes a change of a group's active member or its IP.
+// To and IP are empty if no member is available anymore.
+type FailoverSwitch struct {
+	Group      string
+	From       string
+	To         string
+	IP         string
+	PreviousIP string
+	Version    uint64 // store version of the switch
+}
+
+// NewFailover creates an empty set of failover groups backed by store.
+func NewFailover(store *Store) *Failover {
+	return &Failover{
+		store:    store,
+		groups:   make(map[string]*failoverGroup),
+		groupsOf: make(map[string][]string),
+	}
+}
+
+// Add registers a failover group. Members are listed highest priority first.
+func (f *Failover) Add(name string, members []string, staleAfter time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.groups[name] = &failoverGroup{members: members, staleAfter: staleAfter}
+	for _, member := range members {
+		f.groupsOf[member] = append(f.groupsOf[member], name)
+	}
+}
+
+// Has reports whether name is a failover group.
+func (f *Failover) Has(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.groups[name]
+	return ok
+}
+
+// Groups returns the names of all failover groups.
+func (f *Failover) Groups() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.groups))
+	for name := range f.groups {
+		names = append(names, name)
+	}
+	return names
+}
+
+// GroupsOf returns the groups that name is a member of.
+func (f *Failover) GroupsOf(name string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.groupsOf[name]
+}
+
+// Active returns the active member of a group and its IP, or false if name
+// isn't a group or no member is available.
+func (f *Failover) Active(name string) (member, ip string, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, isGroup := f.groups[name]
+	if !isGroup || g.active == "" {
+		return "", "", false
+	}
+	return g.active, g.ip, true
+}
+
+// Lookup resolves a group to its active member's IP, falling back to the
+// store for regular names.
+func (f *Failover) Lookup(name string) (string, bool) {
+	if !f.Has(name) {
+		return f.store.Get(name)
+	}
+	_, ip, ok := f.Active(name)
+	return ip, ok
+}
+
+// Check re-evaluates the given groups, or every group if none are given,
+// and returns those whose active member or IP changed.
+func (f *Failover) Check(names ...string) []FailoverSwitch {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(names) == 0 {
+		for name := range f.groups {
+			names = append(names, name)
+		}
+	}
+
+	var switches []FailoverSwitch
+	now := time.Now()
+	for _, name := range names {
+		g, ok := f.groups[name]
+		if !ok {
+			continue
+		}
+
+		active, ip := "", ""
+		for _, member := range g.members {
+			memberIP, seen, ok := f.store.Seen(member)
+			if ok && (g.staleAfter <= 0 || now.Sub(seen) < g.staleAfter) {
+				active, ip = member, memberIP
+				break
+			}
+		}
+
+		if active == g.active && ip == g.ip {
+			continue
+		}
+		sw := FailoverSwitch{Group: name, From: g.active, To: active, IP: ip, PreviousIP: g.ip}
+		g.active, g.ip = active, ip
+		sw.Version = f.store.Notify(name)
+		switches = append(switches, sw)
+	}
+	return switches
+}
+
+// interval returns how often groups should be re-checked for stale members.
+func (f *Failover) interval() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	interval := time.Minute
+	for _, g := range f.groups {
+		if g.staleAfter > 0 {
+			interval = min(interval, max(g.staleAfter/10, time.Second))
+		}
+	}
+	return interval
+}
@@ -1,28 +1,40 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/tracyhatemice/who/ddns"
-	"github.com/tracyhatemice/who/webhook"
+	"github.com/tracyhatemice/who/event"
 )
 
 // Server holds the application dependencies.
 type Server struct {
 	store      *Store
+	bus        *event.Bus
 	ddns       *ddns.Dispatcher
-	webhook    *webhook.Dispatcher
+	events     *eventStream
+	history    *history
+	iamToken   string // required for check-ins if set
+	adminToken string // enables the admin API if set
 	verbose    bool
 	configPath string
 	configMu   sync.Mutex // protects config file writes
 	whoNames   map[string]bool
 	aliases    map[string][]string
+	failover   *Failover
+	derived    map[string][]derivedName // prefix source → derived names
 	config     *Config
 }
 
@@ -66,9 +78,8 @@ func (s *Server) whoamiHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) iamHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 
-	// Reject updates for alias names
-	if _, isAlias := s.aliases[name]; isAlias {
-		http.Error(w, "cannot update alias", http.StatusBadRequest)
+	if reason := s.readOnly(name); reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
 		return
 	}
 
@@ -89,76 +100,304 @@ func (s *Server) iamHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	s.checkIn(name, ip, event.SourceIAM)
+	_, _ = fmt.Fprintln(w, ip)
+}
+
+// readOnly returns why name can't be updated, or "" if it can.
+func (s *Server) readOnly(name string) string {
+	switch {
+	case s.aliases[name] != nil:
+		return "cannot update alias"
+	case s.failover.Has(name):
+		return "cannot update failover group"
+	case s.isDerived(name):
+		return "cannot update derived name"
+	}
+	return ""
+}
+
+// checkIn stores the IP of a name, notifies subscribers if it changed and
+// updates the failover groups and derived names depending on it.
+func (s *Server) checkIn(name, ip, source string) {
 	// Store the mapping (thread-safe)
-	changed := s.store.Set(name, ip)
+	previous, version := s.store.Set(name, ip)
 
-	// Trigger side effects if IP changed and name is non-empty
-	if changed && name != "" {
-		// Write back to config file if name is in who config
-		if s.whoNames[name] {
-			go s.saveWhoIP(name, ip)
-		}
-		// Trigger DDNS update (non-blocking)
-		if s.ddns != nil {
-			s.ddns.TriggerUpdate(name, ip)
-		}
-		// Trigger webhook notification (non-blocking)
-		if s.webhook != nil {
-			s.webhook.TriggerWebhook(name, ip)
-		}
+	// Notify subscribers (non-blocking) if IP changed and name is non-empty
+	if version != 0 && name != "" {
+		s.bus.Publish(event.Event{Kind: event.Change, Name: name, Old: previous, New: ip, Source: source, Seq: version})
 	}
 
-	_, _ = fmt.Fprintln(w, ip)
+	// Every check-in refreshes last-seen, so a stale member may be back
+	if groups := s.failover.GroupsOf(name); len(groups) > 0 {
+		s.checkFailover(groups...)
+	}
+
+	// Names derived from this name's prefix check in along with it
+	s.deriveNames(name, ip)
+}
+
+// persist is the event subscriber that writes IPs of names from the who
+// config back to the config file. Removals aren't written, so a name keeps
+// its last known IP across restarts.
+func (s *Server) persist(e event.Event) {
+	if e.New != "" && s.persists(e.Name) {
+		s.saveWhoIP(e.Name, e.New)
+	}
+}
+
+// persists reports whether name's IP is written back to the config file.
+func (s *Server) persists(name string) bool {
+	return s.whoNames[name] && !s.failover.Has(name) && !s.isDerived(name)
 }
 
 // saveWhoIP updates the IP for a who entry in the config file.
 func (s *Server) saveWhoIP(name, ip string) {
+	s.saveWhoIPs(map[string]string{name: ip})
+}
+
+// saveWhoIPs updates the IPs of who entries, keyed by name, and writes the
+// config file once.
+func (s *Server) saveWhoIPs(ips map[string]string) {
 	s.configMu.Lock()
 	defer s.configMu.Unlock()
 
 	for i, entry := range s.config.Who {
-		if entry.IAM == name {
+		if ip, ok := ips[entry.IAM]; ok {
 			s.config.Who[i].IP = ip
-			break
 		}
 	}
 
+	names := slices.Sorted(maps.Keys(ips))
 	if err := SaveConfig(s.configPath, s.config); err != nil {
-		log.Printf("WHO: failed to save config for %s: %v", name, err)
-	} else {
-		log.Printf("WHO: saved %s -> %s to config", name, ip)
+		log.Printf("WHO: failed to save config for %s: %v", strings.Join(names, ", "), err)
+		return
+	}
+	for _, name := range names {
+		log.Printf("WHO: saved %s -> %s to config", name, ips[name])
 	}
 }
 
-func (s *Server) whoisHandler(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
+// expireNames periodically removes names that haven't checked in within
+// maxAge and notifies subscribers. Names from the who config are kept.
+func (s *Server) expireNames(ctx context.Context, maxAge time.Duration) {
+	ticker := time.NewTicker(min(max(maxAge/10, time.Second), time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for name, e := range s.store.Expire(maxAge, s.whoNames) {
+			log.Printf("WHO: %s (%s) expired", name, e.ip)
+			s.bus.Publish(event.Event{Kind: event.Expire, Name: name, Old: e.ip, Source: event.SourceExpire, Seq: e.version})
+			if groups := s.failover.GroupsOf(name); len(groups) > 0 {
+				s.checkFailover(groups...)
+			}
+		}
+	}
+}
 
+// watchFailover periodically re-checks failover groups so that members going
+// stale cause a switch even when nothing checks in.
+func (s *Server) watchFailover(ctx context.Context) {
+	ticker := time.NewTicker(s.failover.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.checkFailover()
+	}
+}
+
+// checkFailover re-evaluates the given failover groups (all if none) and
+// notifies subscribers of groups whose active member changed.
+func (s *Server) checkFailover(groups ...string) {
+	for _, sw := range s.failover.Check(groups...) {
+		e := event.Event{Kind: event.Change, Name: sw.Group, Old: sw.PreviousIP, New: sw.IP, Source: event.SourceFailover, Seq: sw.Version}
+		if sw.To == "" {
+			log.Printf("WHO: failover %s has no available member (was %s)", sw.Group, sw.From)
+			e.Kind = event.Offline
+		} else {
+			log.Printf("WHO: failover %s -> %s (%s)", sw.Group, sw.To, sw.IP)
+		}
+		s.bus.Publish(e)
+	}
+}
+
+// resolve returns the current IPs of a name, failover group or alias.
+// Aliased names without an IP are omitted.
+func (s *Server) resolve(name string) []string {
 	// Check if this is an alias
 	if aliasedNames, isAlias := s.aliases[name]; isAlias {
-		// Resolve all aliased names and return their IPs
 		var ips []string
 		for _, aliasedName := range aliasedNames {
-			if ip, ok := s.store.Get(aliasedName); ok {
+			if ip, ok := s.failover.Lookup(aliasedName); ok {
 				ips = append(ips, ip)
 			}
 		}
-		if len(ips) == 0 {
-			http.NotFound(w, r)
+		return ips
+	}
+
+	// Regular name or failover group lookup
+	if ip, ok := s.failover.Lookup(name); ok {
+		return []string{ip}
+	}
+	return nil
+}
+
+// names returns every stored name, alias and failover group.
+func (s *Server) names() []string {
+	var names []string
+	for name := range s.store.All() {
+		names = append(names, name)
+	}
+	for name := range s.aliases {
+		names = append(names, name)
+	}
+	names = append(names, s.failover.Groups()...)
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// addresses returns the current IP of every name and failover group.
+func (s *Server) addresses() map[string]string {
+	addresses := s.store.All()
+	for _, group := range s.failover.Groups() {
+		if ip, ok := s.failover.Lookup(group); ok {
+			addresses[group] = ip
+		}
+	}
+	return addresses
+}
+
+// snapshot returns the current IPs of every name, alias and failover group.
+func (s *Server) snapshot() map[string][]string {
+	snapshot := make(map[string][]string)
+	for _, name := range s.names() {
+		if ips := s.resolve(name); len(ips) > 0 {
+			snapshot[name] = ips
+		}
+	}
+	return snapshot
+}
+
+// maxWait caps how long a /whois long-poll blocks.
+const maxWait = 10 * time.Minute
+
+// whoisHandler returns the current IPs of a name. With ?wait=, it blocks
+// until the value differs from ?since= (an IP, a comma-separated list of
+// IPs for aliases, or a version) or the wait elapses.
+func (s *Server) whoisHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	query := r.URL.Query()
+
+	var wait time.Duration
+	if v := query.Get("wait"); v != "" {
+		var err error
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+			http.Error(w, "wait must be a duration like 60s", http.StatusBadRequest)
+			return
+		}
+		wait = min(wait, maxWait)
+	}
+	since := query.Get("since")
+
+	// Watch aliases through their members
+	names := append([]string{name}, s.aliases[name]...)
+
+	var timeout <-chan time.Time
+	if wait > 0 && since != "" {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		version, changed, stop := s.store.Watch(names...)
+		ips := s.resolve(name)
+		if timeout == nil || differs(since, version, ips) {
+			stop()
+			w.Header().Set("X-Who-Version", strconv.FormatUint(version, 10))
+			if len(ips) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			for _, ip := range ips {
+				_, _ = fmt.Fprintln(w, ip)
+			}
 			return
 		}
-		for _, ip := range ips {
-			_, _ = fmt.Fprintln(w, ip)
+
+		select {
+		case <-changed:
+			stop()
+		case <-timeout:
+			stop()
+			w.Header().Set("X-Who-Version", strconv.FormatUint(version, 10))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-r.Context().Done():
+			stop()
+			return
 		}
+	}
+}
+
+// differs reports whether the current value of a name differs from since,
+// which is either a version number or the IPs joined by commas.
+func differs(since string, version uint64, ips []string) bool {
+	if v, err := strconv.ParseUint(since, 10, 64); err == nil {
+		return v != version
+	}
+	return since != strings.Join(ips, ",")
+}
+
+// authHandler implements a Traefik ForwardAuth endpoint: it allows the
+// request if the client IP matches a current IP of the name or alias.
+func (s *Server) authHandler(w http.ResponseWriter, r *http.Request) {
+	widen, err := parseWidening(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Regular name lookup
-	ip, ok := s.store.Get(name)
-	if !ok {
-		http.NotFound(w, r)
+	client, err := netip.ParseAddr(getClientIP(r))
+	if err != nil {
+		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
-	_, _ = fmt.Fprintln(w, ip)
+	client = client.Unmap()
+
+	for _, ip := range s.resolve(r.PathValue("name")) {
+		if prefix, ok := widen.prefix(ip); ok && prefix.Contains(client) {
+			_, _ = fmt.Fprintln(w, "OK")
+			return
+		}
+	}
+	http.Error(w, "forbidden", http.StatusForbidden)
+}
+
+// statusResponse is the JSON body returned by /status.
+type statusResponse struct {
+	DDNS []ddns.Status `json:"ddns"`
+}
+
+func (s *Server) statusHandler(w http.ResponseWriter, _ *http.Request) {
+	resp := statusResponse{DDNS: []ddns.Status{}}
+	if s.ddns != nil {
+		resp.DDNS = s.ddns.Status()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
 // responseCapture wraps ResponseWriter to capture the response body.
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWhoisServer serves /whois for a store holding ips, with the given aliases.
+func newWhoisServer(t *testing.T, ips map[string]string, aliases map[string][]string) (*Store, *httptest.Server) {
+	t.Helper()
+	store := NewStore()
+	for name, ip := range ips {
+		store.Set(name, ip)
+	}
+	s := &Server{store: store, failover: NewFailover(store), aliases: aliases}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /whois/{name}", s.whoisHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return store, srv
+}
+
+// whois is a response of /whois.
+type whois struct {
+	status  int
+	version uint64
+	body    string
+}
+
+func getWhois(t *testing.T, srv *httptest.Server, path string) whois {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := strconv.ParseUint(resp.Header.Get("X-Who-Version"), 10, 64)
+	if err != nil {
+		t.Fatalf("X-Who-Version: %v", err)
+	}
+	return whois{resp.StatusCode, version, strings.TrimSpace(string(body))}
+}
+
+// getWhoisAsync requests path in the background.
+func getWhoisAsync(t *testing.T, srv *httptest.Server, path string) <-chan whois {
+	ch := make(chan whois, 1)
+	go func() {
+		resp, err := srv.Client().Get(srv.URL + path)
+		if err != nil {
+			t.Error(err)
+			close(ch)
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		version, _ := strconv.ParseUint(resp.Header.Get("X-Who-Version"), 10, 64)
+		ch <- whois{resp.StatusCode, version, strings.TrimSpace(string(body))}
+	}()
+	return ch
+}
+
+// pending fails if the long-poll in ch already returned.
+func pending(t *testing.T, ch <-chan whois) {
+	t.Helper()
+	select {
+	case w := <-ch:
+		t.Fatalf("returned %+v before a change", w)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func receive(t *testing.T, ch <-chan whois) whois {
+	t.Helper()
+	select {
+	case w := <-ch:
+		return w
+	case <-time.After(5 * time.Second):
+		t.Fatal("long-poll didn't return")
+		return whois{}
+	}
+}
+
+func TestWhoisWaitSinceIP(t *testing.T) {
+	store, srv := newWhoisServer(t, map[string]string{"nas": "192.168.1.10"}, nil)
+
+	// A since that already differs returns at once
+	if w := getWhois(t, srv, "/whois/nas?wait=60s&since=192.168.1.9"); w.status != http.StatusOK || w.body != "192.168.1.10" {
+		t.Errorf("since an older IP: %+v", w)
+	}
+
+	ch := getWhoisAsync(t, srv, "/whois/nas?wait=60s&since=192.168.1.10")
+	pending(t, ch)
+	store.Set("nas", "192.168.1.11")
+	if w := receive(t, ch); w.status != http.StatusOK || w.body != "192.168.1.11" {
+		t.Errorf("after a change: %+v", w)
+	}
+}
+
+func TestWhoisWaitSinceVersion(t *testing.T) {
+	store, srv := newWhoisServer(t, map[string]string{"nas": "192.168.1.10", "laptop": "10.0.0.5"}, nil)
+	current := getWhois(t, srv, "/whois/nas")
+	if current.status != http.StatusOK || current.version == 0 {
+		t.Fatalf("without wait: %+v", current)
+	}
+
+	// Changes of other names don't wake the long-poll, and checking in
+	// with the same IP isn't a change
+	since := strconv.FormatUint(current.version, 10)
+	ch := getWhoisAsync(t, srv, "/whois/nas?wait=60s&since="+since)
+	store.Set("laptop", "10.0.0.6")
+	store.Set("nas", "192.168.1.10")
+	pending(t, ch)
+
+	store.Set("nas", "192.168.1.11")
+	w := receive(t, ch)
+	if w.status != http.StatusOK || w.body != "192.168.1.11" || w.version <= current.version {
+		t.Errorf("after a change: %+v, since version %d", w, current.version)
+	}
+
+	// A stale version returns at once
+	if w := getWhois(t, srv, "/whois/nas?wait=60s&since="+since); w.body != "192.168.1.11" {
+		t.Errorf("since a stale version: %+v", w)
+	}
+}
+
+func TestWhoisWaitTimeout(t *testing.T) {
+	_, srv := newWhoisServer(t, map[string]string{"nas": "192.168.1.10"}, nil)
+	current := getWhois(t, srv, "/whois/nas")
+
+	start := time.Now()
+	w := getWhois(t, srv, "/whois/nas?wait=200ms&since=192.168.1.10")
+	if w.status != http.StatusNotModified || w.version != current.version {
+		t.Errorf("after the wait: %+v, want 304 with version %d", w, current.version)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("returned after %s, before the wait elapsed", elapsed)
+	}
+
+	// Without since, wait is ignored
+	if w := getWhois(t, srv, "/whois/nas?wait=60s"); w.status != http.StatusOK {
+		t.Errorf("without since: %+v", w)
+	}
+}
+
+func TestWhoisWaitAlias(t *testing.T) {
+	store, srv := newWhoisServer(t, map[string]string{"nas": "192.168.1.10"}, map[string][]string{"home": {"nas", "laptop"}})
+
+	// An alias changes when any member does, including one checking in
+	// for the first time
+	ch := getWhoisAsync(t, srv, "/whois/home?wait=60s&since=192.168.1.10")
+	pending(t, ch)
+	store.Set("laptop", "10.0.0.5")
+	if w := receive(t, ch); w.status != http.StatusOK || w.body != "192.168.1.10\n10.0.0.5" {
+		t.Errorf("after a member checked in: %+v", w)
+	}
+
+	ch = getWhoisAsync(t, srv, "/whois/home?wait=60s&since=192.168.1.10,10.0.0.5")
+	pending(t, ch)
+	store.Delete("nas")
+	if w := receive(t, ch); w.status != http.StatusOK || w.body != "10.0.0.5" {
+		t.Errorf("after a member was removed: %+v", w)
+	}
+}
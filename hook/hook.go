@@ -0,0 +1,220 @@
+package hook
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tracyhatemice/who/event"
+)
+
+// defaultTimeout bounds how long a command may run if no timeout is set.
+const defaultTimeout = 30 * time.Second
+
+// Entry represents an exec hook configuration.
+type Entry struct {
+	IAM     string
+	Command []string
+	Timeout time.Duration
+}
+
+// Config holds exec hook configuration from main config.
+type Config struct {
+	IAM           string
+	Command       []string
+	Timeout       time.Duration
+	MaxConcurrent int
+}
+
+// Dispatcher manages exec hooks and runs them when IPs change.
+type Dispatcher struct {
+	workers map[string]*worker // IAM → hooks and their pending change
+	slots   chan struct{}      // limits how many names run hooks at once
+
+	mu     sync.Mutex // protects pending changes
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// worker runs the hooks of one name, one change after another.
+type worker struct {
+	name    string
+	entries []*Entry
+	pending *change // latest change not yet run, nil if none
+	wake    chan struct{}
+}
+
+// change is an IP change of a name to run its hooks for.
+type change struct {
+	ip, previous string
+}
+
+// NewDispatcher creates a Dispatcher from configuration.
+func NewDispatcher(configs []Config) *Dispatcher {
+	d := &Dispatcher{workers: make(map[string]*worker)}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	// Hooks of different names run concurrently up to the largest
+	// configured limit
+	limit := 1
+	for _, cfg := range configs {
+		if cfg.IAM == "" || len(cfg.Command) == 0 {
+			log.Printf("EXEC: skipping entry with empty IAM or command")
+			continue
+		}
+
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		limit = max(limit, cfg.MaxConcurrent)
+
+		w, ok := d.workers[cfg.IAM]
+		if !ok {
+			w = &worker{name: cfg.IAM, wake: make(chan struct{}, 1)}
+			d.workers[cfg.IAM] = w
+		}
+		w.entries = append(w.entries, &Entry{
+			IAM:     cfg.IAM,
+			Command: cfg.Command,
+			Timeout: timeout,
+		})
+	}
+
+	d.slots = make(chan struct{}, limit)
+	for _, w := range d.workers {
+		d.wg.Go(func() { d.work(w) })
+	}
+	return d
+}
+
+// Handle implements event.Subscriber.
+func (d *Dispatcher) Handle(e event.Event) {
+	d.TriggerExec(e.Name, e.New, e.Old)
+}
+
+// TriggerExec queues the hooks for name to run in the background. ip is
+// empty if the name was removed. A change still queued from an earlier
+// call is replaced, so a burst of changes runs the hooks once with the
+// latest IP, and the IP before the burst as the previous one.
+func (d *Dispatcher) TriggerExec(name, ip, previous string) {
+	w, ok := d.workers[name]
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	if w.pending != nil {
+		previous = w.pending.previous
+	}
+	w.pending = &change{ip: ip, previous: previous}
+	d.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default: // already signalled
+	}
+}
+
+// Close cancels running commands and waits for them to exit.
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// work runs the hooks of a name for its pending change until the
+// dispatcher is closed. Hooks run in the order they are configured, once a
+// slot is free.
+func (d *Dispatcher) work(w *worker) {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-w.wake:
+		}
+
+		d.mu.Lock()
+		c := w.pending
+		w.pending = nil
+		d.mu.Unlock()
+		if c == nil || c.ip == c.previous {
+			continue // a burst ended where it started
+		}
+
+		select {
+		case d.slots <- struct{}{}:
+		case <-d.ctx.Done():
+			return
+		}
+		env := append(os.Environ(),
+			"WHO_NAME="+w.name,
+			"WHO_IP="+c.ip,
+			"WHO_PREVIOUS_IP="+c.previous,
+			"WHO_FAMILY="+family(c.ip),
+		)
+		for _, entry := range w.entries {
+			if d.ctx.Err() != nil {
+				break
+			}
+			d.run(entry, env)
+		}
+		<-d.slots
+	}
+}
+
+// run executes a hook. The command is started directly, not through a
+// shell, so values from requests are only ever seen as environment
+// variables.
+func (d *Dispatcher) run(e *Entry, env []string) {
+	ctx, cancel := context.WithTimeout(d.ctx, e.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...)
+	cmd.Env = env
+	cmd.WaitDelay = time.Second // don't wait on children left holding the output pipe
+	out, w := io.Pipe()
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			log.Printf("EXEC: %s: %s", e.IAM, scanner.Text())
+		}
+		_, _ = io.Copy(io.Discard, out) // drain over-long lines
+	}()
+
+	log.Printf("EXEC: running %s for IAM %s", strings.Join(e.Command, " "), e.IAM)
+	err := cmd.Run()
+	_ = w.Close()
+	<-done
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		log.Printf("EXEC: %s for IAM %s timed out after %s", e.Command[0], e.IAM, e.Timeout)
+	case err != nil:
+		log.Printf("EXEC: %s for IAM %s failed: %v", e.Command[0], e.IAM, err)
+	}
+}
+
+// family returns "ipv4" or "ipv6" for ip, or "" if ip is empty.
+func family(ip string) string {
+	switch {
+	case ip == "":
+		return ""
+	case strings.Contains(ip, ":"):
+		return "ipv6"
+	default:
+		return "ipv4"
+	}
+}
@@ -0,0 +1,171 @@
+package hook
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe to use as log output.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog redirects the log to a buffer for the rest of the test.
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return buf
+}
+
+// script returns a hook command running a shell script in dir, which the
+// script sees as $DIR.
+func script(dir, body string) []string {
+	return []string{"sh", "-c", "DIR=" + dir + "; " + body}
+}
+
+// waitForLines waits until the file at path holds want, line by line.
+func waitForLines(t *testing.T, path string, want ...string) {
+	t.Helper()
+	var got []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		data, _ := os.ReadFile(path)
+		got = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if slices.Equal(got, want) {
+			return
+		}
+	}
+	t.Fatalf("%s holds %q, want %q", filepath.Base(path), got, want)
+}
+
+func newTestDispatcher(t *testing.T, configs ...Config) *Dispatcher {
+	t.Helper()
+	d := NewDispatcher(configs)
+	t.Cleanup(d.Close)
+	return d
+}
+
+func TestExecEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDispatcher(t, Config{
+		IAM:     "nas",
+		Command: script(dir, `echo "$WHO_NAME,$WHO_IP,$WHO_PREVIOUS_IP,$WHO_FAMILY" >> "$DIR/out"`),
+	})
+
+	d.TriggerExec("nas", "2001:db8::1", "")
+	waitForLines(t, filepath.Join(dir, "out"), "nas,2001:db8::1,,ipv6")
+	d.TriggerExec("nas", "", "2001:db8::1")
+	waitForLines(t, filepath.Join(dir, "out"), "nas,2001:db8::1,,ipv6", "nas,,2001:db8::1,")
+}
+
+func TestExecRunsLatestChangeInOrder(t *testing.T) {
+	dir := t.TempDir()
+	// The hook blocks until released, so changes queue up behind it
+	d := newTestDispatcher(t,
+		Config{IAM: "nas", Command: script(dir, `echo "$WHO_PREVIOUS_IP>$WHO_IP" >> "$DIR/out"; until [ -e "$DIR/release" ]; do sleep 0.01; done`)},
+		Config{IAM: "nas", Command: script(dir, `echo "second:$WHO_IP" >> "$DIR/out"`)},
+	)
+	out := filepath.Join(dir, "out")
+
+	d.TriggerExec("nas", "10.0.0.1", "")
+	waitForLines(t, out, ">10.0.0.1")
+
+	// Queued changes are coalesced; the hooks see the IP before the burst
+	d.TriggerExec("nas", "10.0.0.2", "10.0.0.1")
+	d.TriggerExec("nas", "10.0.0.3", "10.0.0.2")
+	if err := os.WriteFile(filepath.Join(dir, "release"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitForLines(t, out, ">10.0.0.1", "second:10.0.0.1", "10.0.0.1>10.0.0.3", "second:10.0.0.3")
+}
+
+func TestExecBurstBackToStart(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDispatcher(t, Config{
+		IAM:     "nas",
+		Command: script(dir, `echo "$WHO_IP" >> "$DIR/out"; until [ -e "$DIR/release" ]; do sleep 0.01; done`),
+	})
+	out := filepath.Join(dir, "out")
+
+	d.TriggerExec("nas", "10.0.0.1", "")
+	waitForLines(t, out, "10.0.0.1")
+
+	// A burst ending at the IP the hooks last saw doesn't run them
+	d.TriggerExec("nas", "10.0.0.2", "10.0.0.1")
+	d.TriggerExec("nas", "10.0.0.1", "10.0.0.2")
+	d.TriggerExec("nas", "10.0.0.4", "10.0.0.1")
+	d.TriggerExec("nas", "10.0.0.1", "10.0.0.4")
+	d.TriggerExec("nas", "10.0.0.5", "10.0.0.1")
+	if err := os.WriteFile(filepath.Join(dir, "release"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitForLines(t, out, "10.0.0.1", "10.0.0.5")
+}
+
+func TestExecMaxConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	command := script(dir, `echo "$WHO_NAME" >> "$DIR/out"; until [ -e "$DIR/release" ]; do sleep 0.01; done`)
+	d := newTestDispatcher(t,
+		Config{IAM: "nas", Command: command},
+		Config{IAM: "laptop", Command: command},
+		Config{IAM: "phone", Command: command, MaxConcurrent: 2},
+	)
+	out := filepath.Join(dir, "out")
+
+	d.TriggerExec("nas", "10.0.0.1", "")
+	waitForLines(t, out, "nas")
+	d.TriggerExec("laptop", "10.0.0.2", "")
+	waitForLines(t, out, "nas", "laptop")
+
+	// Both slots are taken, so phone waits for one of them
+	d.TriggerExec("phone", "10.0.0.3", "")
+	time.Sleep(100 * time.Millisecond)
+	waitForLines(t, out, "nas", "laptop")
+	if err := os.WriteFile(filepath.Join(dir, "release"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitForLines(t, out, "nas", "laptop", "phone")
+}
+
+func TestExecTimeout(t *testing.T) {
+	logs := captureLog(t)
+	dir := t.TempDir()
+	d := newTestDispatcher(t, Config{
+		IAM:     "nas",
+		Command: script(dir, `echo "$WHO_IP" >> "$DIR/out"; exec sleep 10`),
+		Timeout: 100 * time.Millisecond,
+	})
+	out := filepath.Join(dir, "out")
+
+	// The next change runs once the first command is killed, long before
+	// it would have exited on its own
+	d.TriggerExec("nas", "10.0.0.1", "")
+	waitForLines(t, out, "10.0.0.1")
+	d.TriggerExec("nas", "10.0.0.2", "10.0.0.1")
+	waitForLines(t, out, "10.0.0.1", "10.0.0.2")
+
+	if !strings.Contains(logs.String(), "EXEC: sh for IAM nas timed out after 100ms") {
+		t.Errorf("log doesn't report the timeout:\n%s", logs)
+	}
+}
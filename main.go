@@ -1,24 +1,60 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/tracyhatemice/who/ddns"
+	"github.com/tracyhatemice/who/event"
+	"github.com/tracyhatemice/who/hook"
+	"github.com/tracyhatemice/who/mqtt"
+	"github.com/tracyhatemice/who/sink"
 	"github.com/tracyhatemice/who/webhook"
 )
 
 func main() {
+	// Subcommands; without one, run the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "client":
+			clientMain(os.Args[2:])
+			return
+		case "names":
+			namesMain(os.Args[2:])
+			return
+		case "history":
+			historyMain(os.Args[2:])
+			return
+		case "events":
+			eventsMain(os.Args[2:])
+			return
+		case "export":
+			exportMain(os.Args[2:])
+			return
+		case "import":
+			importMain(os.Args[2:])
+			return
+		}
+	}
+
 	// Parse flags
 	var (
 		port       string
 		verbose    bool
 		configPath string
+		expire     time.Duration
 	)
 	flag.StringVar(&port, "port", "80", "Port number to listen on")
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose logging")
 	flag.StringVar(&configPath, "config", "", "Path to config file (optional)")
+	flag.DurationVar(&expire, "expire", 0, "Remove names that haven't checked in for this long (0 disables)")
 	flag.Parse()
 
 	// Load configuration
@@ -27,28 +63,115 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// Build who names set, pre-load IPs into store, and load aliases
+	store := NewStore()
+	whoNames := make(map[string]bool)
+	aliases := make(map[string][]string)
+	failover := NewFailover(store)
+	derived := make(map[string][]derivedName) // prefix source → derived names
+	groups := 0
+	for _, entry := range cfg.Who {
+		if entry.IAM != "" {
+			whoNames[entry.IAM] = true
+			if len(entry.Alias) > 0 {
+				// This is an alias entry
+				aliases[entry.IAM] = entry.Alias
+			} else if len(entry.Failover) > 0 {
+				// This is a failover group
+				failover.Add(entry.IAM, entry.Failover, time.Duration(entry.StaleAfter))
+				groups++
+			} else if entry.PrefixFrom != "" {
+				// This name is derived from another name's prefix
+				d, err := newDerivedName(entry)
+				if err != nil {
+					log.Fatalf("Invalid who entry %s: %v", entry.IAM, err)
+				}
+				derived[entry.PrefixFrom] = append(derived[entry.PrefixFrom], d)
+			} else if entry.IP != "" {
+				// This is a regular IP entry. It hasn't checked in since
+				// the restart, so it isn't fresh for failover groups.
+				store.Restore(entry.IAM, entry.IP, time.Time{})
+			}
+		}
+	}
+	if len(whoNames) > 0 {
+		log.Printf("WHO: pre-loaded %d entries (%d aliases, %d failover groups)", len(whoNames), len(aliases), groups)
+	}
+	for source, names := range derived {
+		if ip, ok := store.Get(source); ok {
+			for _, d := range names {
+				if addr, ok := d.address(ip); ok {
+					store.Restore(d.name, addr, time.Time{})
+				}
+			}
+		}
+	}
+	failover.Check()
+
+	// Create server with dependencies. Side effects of changes are event
+	// subscribers, notified in the order they subscribe.
+	bus := event.NewBus()
+	server := &Server{
+		store:      store,
+		bus:        bus,
+		verbose:    verbose,
+		configPath: configPath,
+		whoNames:   whoNames,
+		aliases:    aliases,
+		failover:   failover,
+		derived:    derived,
+		events:     newEventStream(),
+		history:    newHistory(),
+		config:     cfg,
+	}
+	bus.Subscribe(event.SubscriberFunc(server.persist))
+	bus.Subscribe(server.history)
+	var closers []func() // dispatchers to stop on shutdown
+
 	// Initialize DDNS dispatcher
-	var ddnsDispatcher *ddns.Dispatcher
 	if len(cfg.DDNS) > 0 {
 		ddnsConfigs := make([]ddns.Config, len(cfg.DDNS))
 		for i, entry := range cfg.DDNS {
+			targets := make([]ddns.Target, len(entry.Targets))
+			for j, t := range entry.Targets {
+				targets[j] = ddns.Target{IAM: t.IAM, Domain: t.Domain}
+			}
 			ddnsConfigs[i] = ddns.Config{
-				Provider:  entry.Provider,
-				Domain:    entry.Domain,
-				IPVersion: entry.IPVersion,
-				IAM:       entry.IAM,
-				AccessKey: entry.AccessKey,
-				SecretKey: entry.SecretKey,
-				ZoneID:    entry.ZoneID,
-				TTL:       entry.TTL,
+				Provider:     entry.Provider,
+				Domain:       entry.Domain,
+				IPVersion:    entry.IPVersion,
+				IAM:          entry.IAM,
+				Mode:         entry.Mode,
+				Targets:      targets,
+				StaleAfter:   time.Duration(entry.StaleAfter),
+				TXT:          entry.TXT,
+				AccessKey:    entry.accessKey,
+				SecretKey:    entry.secretKey,
+				ZoneID:       entry.ZoneID,
+				TTL:          entry.TTL,
+				SyncTimeout:  time.Duration(entry.SyncTimeout),
+				Debounce:     time.Duration(entry.Debounce),
+				MinInterval:  time.Duration(entry.MinInterval),
+				BatchWindow:  time.Duration(entry.BatchWindow),
+				IPv6Suffix:   entry.IPv6Suffix,
+				PrefixLength: entry.PrefixLength,
+			}
+		}
+		// A failover group is as recent as its active member
+		seen := func(name string) (time.Time, bool) {
+			if member, _, ok := failover.Active(name); ok {
+				name = member
 			}
+			_, seen, ok := store.Seen(name)
+			return seen, ok
 		}
-		ddnsDispatcher = ddns.NewDispatcher(ddnsConfigs)
+		server.ddns = ddns.NewDispatcher(ddnsConfigs, aliases, failover.Lookup, seen)
+		closers = append(closers, server.ddns.Close)
+		bus.Subscribe(server.ddns)
 		log.Printf("DDNS: loaded %d entries", len(cfg.DDNS))
 	}
 
 	// Initialize webhook dispatcher
-	var webhookDispatcher *webhook.Dispatcher
 	if len(cfg.Webhooks) > 0 {
 		webhookConfigs := make([]webhook.Config, len(cfg.Webhooks))
 		for i, entry := range cfg.Webhooks {
@@ -56,52 +179,130 @@ func main() {
 				IAM:     entry.IAM,
 				URL:     entry.URL,
 				Method:  entry.Method,
-				Headers: entry.Headers,
+				Headers: entry.headers,
 			}
 		}
-		webhookDispatcher = webhook.NewDispatcher(webhookConfigs)
+		bus.Subscribe(webhook.NewDispatcher(webhookConfigs))
 		log.Printf("WEBHOOK: loaded %d entries", len(cfg.Webhooks))
 	}
 
-	// Build who names set, pre-load IPs into store, and load aliases
-	store := NewStore()
-	whoNames := make(map[string]bool)
-	aliases := make(map[string][]string)
-	for _, entry := range cfg.Who {
-		if entry.IAM != "" {
-			whoNames[entry.IAM] = true
-			if len(entry.Alias) > 0 {
-				// This is an alias entry
-				aliases[entry.IAM] = entry.Alias
-			} else if entry.IP != "" {
-				// This is a regular IP entry
-				store.Set(entry.IAM, entry.IP)
+	// Initialize file sinks, rendering each one from the pre-loaded names
+	if len(cfg.Sinks) > 0 {
+		sinkConfigs := make([]sink.Config, len(cfg.Sinks))
+		for i, entry := range cfg.Sinks {
+			sinkConfigs[i] = sink.Config{
+				Path:         entry.Path,
+				Template:     entry.Template,
+				TemplateFile: entry.TemplateFile,
+				IAM:          entry.IAM,
+				Command:      entry.Command,
 			}
 		}
+		sinkDispatcher := sink.NewDispatcher(sinkConfigs, aliases, server.snapshot)
+		closers = append(closers, sinkDispatcher.Close)
+		bus.Subscribe(sinkDispatcher)
+		log.Printf("SINK: loaded %d entries", len(cfg.Sinks))
 	}
-	if len(whoNames) > 0 {
-		log.Printf("WHO: pre-loaded %d entries (%d aliases)", len(whoNames), len(aliases))
+
+	// Initialize exec hooks
+	if len(cfg.Exec) > 0 {
+		hookConfigs := make([]hook.Config, len(cfg.Exec))
+		for i, entry := range cfg.Exec {
+			hookConfigs[i] = hook.Config{
+				IAM:           entry.IAM,
+				Command:       entry.Command,
+				Timeout:       time.Duration(entry.Timeout),
+				MaxConcurrent: entry.MaxConcurrent,
+			}
+		}
+		hookDispatcher := hook.NewDispatcher(hookConfigs)
+		closers = append(closers, hookDispatcher.Close)
+		bus.Subscribe(hookDispatcher)
+		log.Printf("EXEC: loaded %d entries", len(cfg.Exec))
 	}
 
-	// Create server with dependencies
-	server := &Server{
-		store:      store,
-		ddns:       ddnsDispatcher,
-		webhook:    webhookDispatcher,
-		verbose:    verbose,
-		configPath: configPath,
-		whoNames:   whoNames,
-		aliases:    aliases,
-		config:     cfg,
+	// Connect to the MQTT broker in the background
+	if cfg.MQTT != nil {
+		publisher, err := mqtt.NewPublisher(mqtt.Config{
+			URL:             cfg.MQTT.URL,
+			Username:        cfg.MQTT.Username,
+			Password:        cfg.MQTT.password,
+			ClientID:        cfg.MQTT.ClientID,
+			TopicPrefix:     cfg.MQTT.TopicPrefix,
+			Discovery:       cfg.MQTT.Discovery,
+			DiscoveryPrefix: cfg.MQTT.DiscoveryPrefix,
+			KeepAlive:       time.Duration(cfg.MQTT.KeepAlive),
+		}, server.addresses)
+		if err != nil {
+			log.Fatalf("Failed to configure MQTT: %v", err)
+		}
+		closers = append(closers, publisher.Close)
+		bus.Subscribe(publisher)
 	}
 
+	bus.Subscribe(server.events)
+
 	// Setup routes
+	if cfg.CheckIn != nil && cfg.CheckIn.token != "" {
+		server.iamToken = cfg.CheckIn.token
+		log.Printf("WHO: check-ins require a token")
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /whoami", server.withLogging(server.whoamiHandler))
-	mux.HandleFunc("GET /iam/{name}", server.withLogging(server.iamHandler))
-	mux.HandleFunc("GET /iam/{name}/{ip}", server.withLogging(server.iamHandler))
+	mux.HandleFunc("GET /iam/{name}", server.withLogging(server.withIAM(server.iamHandler)))
+	mux.HandleFunc("GET /iam/{name}/{ip}", server.withLogging(server.withIAM(server.iamHandler)))
 	mux.HandleFunc("GET /whois/{name}", server.withLogging(server.whoisHandler))
+	mux.HandleFunc("GET /auth/{name}", server.withLogging(server.authHandler))
+	mux.HandleFunc("GET /traefik/dynamic", server.withLogging(server.traefikHandler))
+	mux.HandleFunc("GET /export/hosts", server.withLogging(server.hostsHandler))
+	mux.HandleFunc("GET /export/zone/{zone}", server.withLogging(server.zoneHandler))
+	mux.HandleFunc("GET /export/{name}", server.withLogging(server.exportHandler))
+	mux.HandleFunc("GET /status", server.withLogging(server.statusHandler))
+	// Streams aren't wrapped in withLogging, which buffers the response body
+	mux.HandleFunc("GET /events", server.eventsHandler)
+	mux.HandleFunc("GET /events/{name}", server.eventsHandler)
+	if cfg.Admin != nil && cfg.Admin.token != "" {
+		server.adminToken = cfg.Admin.token
+		mux.HandleFunc("GET /admin/names", server.withLogging(server.withAdmin(server.adminNamesHandler)))
+		mux.HandleFunc("PUT /admin/names/{name}", server.withLogging(server.withAdmin(server.adminSetHandler)))
+		mux.HandleFunc("DELETE /admin/names/{name}", server.withLogging(server.withAdmin(server.adminDeleteHandler)))
+		mux.HandleFunc("GET /admin/history/{name}", server.withLogging(server.withAdmin(server.adminHistoryHandler)))
+		// Dumps can be large, so they aren't wrapped in withLogging
+		mux.HandleFunc("GET /admin/export", server.withAdmin(server.adminExportHandler))
+		mux.HandleFunc("POST /admin/import", server.withAdmin(server.adminImportHandler))
+		log.Printf("WHO: admin API enabled")
+	}
+
+	// Stop on SIGINT/SIGTERM, cancelling in-flight DDNS updates
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if expire > 0 {
+		go server.expireNames(ctx, expire)
+		log.Printf("WHO: expiring names after %s", expire)
+	}
+	if groups > 0 {
+		go server.watchFailover(ctx)
+	}
+
+	httpServer := &http.Server{Addr: ":" + port, Handler: mux}
+	httpServer.RegisterOnShutdown(server.events.Close)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
 
 	log.Printf("Starting up on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, mux))
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+
+	// Deliver pending events before stopping the dispatchers
+	bus.Close()
+	for _, shutdown := range closers {
+		shutdown()
+	}
+	log.Printf("Shut down")
 }
@@ -0,0 +1,324 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/tracyhatemice/who/event"
+)
+
+const (
+	defaultTopicPrefix     = "who"
+	defaultDiscoveryPrefix = "homeassistant"
+	defaultKeepAlive       = 60 * time.Second
+	maxKeepAlive           = 65535 * time.Second // CONNECT encodes seconds in 16 bits
+	dialTimeout            = 10 * time.Second
+	writeTimeout           = 10 * time.Second
+	maxBackoff             = time.Minute
+	queueSize              = 256
+)
+
+// Config holds MQTT configuration from main config.
+type Config struct {
+	URL             string // mqtt://host:1883 or mqtts://host:8883
+	Username        string
+	Password        string
+	ClientID        string
+	TopicPrefix     string
+	Discovery       bool
+	DiscoveryPrefix string
+	KeepAlive       time.Duration
+}
+
+// AddressesFunc returns the current IP of every name.
+type AddressesFunc func() map[string]string
+
+// Event is the JSON payload published to <prefix>/events.
+type Event struct {
+	IAM        string `json:"iam"`
+	IP         string `json:"ip"`
+	PreviousIP string `json:"previous_ip"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// discoveryConfig is a Home Assistant MQTT discovery payload for a sensor.
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	Icon              string          `json:"icon"`
+	Device            discoveryDevice `json:"device"`
+}
+
+type discoveryDevice struct {
+	Identifiers []string `json:"identifiers"`
+	Name        string   `json:"name"`
+}
+
+// message is a queued change of a name.
+type message struct {
+	name     string
+	ip       string
+	previous string
+	time     time.Time
+}
+
+// Publisher keeps a connection to an MQTT broker and publishes IP changes.
+// The current IP of every name is published retained to <prefix>/<name>/ip,
+// and each change as an Event to <prefix>/events.
+type Publisher struct {
+	cfg       Config
+	addresses AddressesFunc
+	queue     chan message
+	announced map[string]bool // names with published discovery config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPublisher creates a Publisher and starts connecting to the broker.
+func NewPublisher(cfg Config, addresses AddressesFunc) (*Publisher, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "mqtt" && u.Scheme != "mqtts" {
+		return nil, fmt.Errorf("unsupported scheme %q, use mqtt:// or mqtts://", u.Scheme)
+	}
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = defaultTopicPrefix
+	}
+	if cfg.DiscoveryPrefix == "" {
+		cfg.DiscoveryPrefix = defaultDiscoveryPrefix
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = defaultKeepAlive
+	}
+	if cfg.KeepAlive < time.Second || cfg.KeepAlive > maxKeepAlive {
+		return nil, fmt.Errorf("keep_alive must be between 1s and %s", maxKeepAlive)
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "who"
+	}
+
+	p := &Publisher{
+		cfg:       cfg,
+		addresses: addresses,
+		queue:     make(chan message, queueSize),
+		announced: make(map[string]bool),
+		done:      make(chan struct{}),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	go p.run(u)
+	return p, nil
+}
+
+// Handle implements event.Subscriber.
+func (p *Publisher) Handle(e event.Event) {
+	p.TriggerPublish(e.Name, e.New, e.Old)
+}
+
+// TriggerPublish queues a change of name for publishing. ip is empty if the
+// name was removed, which clears its retained message.
+func (p *Publisher) TriggerPublish(name, ip, previous string) {
+	select {
+	case p.queue <- message{name: name, ip: ip, previous: previous, time: time.Now()}:
+	default:
+		log.Printf("MQTT: queue full, dropping change of %s", name)
+	}
+}
+
+// Close publishes the offline status, disconnects and stops reconnecting.
+func (p *Publisher) Close() {
+	p.cancel()
+	<-p.done
+}
+
+// run connects to the broker and reconnects with exponential backoff until
+// the publisher is closed.
+func (p *Publisher) run(u *url.URL) {
+	defer close(p.done)
+
+	backoff := time.Second
+	for {
+		connected, err := p.session(u)
+		if p.ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		log.Printf("MQTT: connection to %s failed: %v, retrying in %s", u.Host, err, backoff)
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// session runs a single connection until it fails or the publisher is
+// closed, and reports whether the broker accepted the connection.
+func (p *Publisher) session(u *url.URL) (bool, error) {
+	conn, err := p.dial(u)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	statusTopic := p.cfg.TopicPrefix + "/status"
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+	if _, err := conn.Write(connect{
+		clientID:    p.cfg.ClientID,
+		username:    p.cfg.Username,
+		password:    p.cfg.Password,
+		keepAlive:   uint16(p.cfg.KeepAlive / time.Second),
+		willTopic:   statusTopic,
+		willMessage: []byte("offline"),
+		willRetain:  true,
+	}.encode()); err != nil {
+		return false, err
+	}
+	ack, err := readPacket(r)
+	if err != nil {
+		return false, err
+	}
+	if err := connackError(ack); err != nil {
+		return false, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	log.Printf("MQTT: connected to %s", u.Host)
+
+	write := func(b []byte) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		_, err := conn.Write(b)
+		return err
+	}
+
+	// Retained state may be stale after an outage, so republish all of it
+	clear(p.announced)
+	if err := write(encodePublish(statusTopic, []byte("online"), true)); err != nil {
+		return true, err
+	}
+	for name, ip := range p.addresses() {
+		if err := p.publishIP(write, name, ip); err != nil {
+			return true, err
+		}
+	}
+
+	// Read PINGRESPs so a dead connection is noticed
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(2 * p.cfg.KeepAlive))
+			if _, err := readPacket(r); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(p.cfg.KeepAlive / 2)
+	defer ping.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			_ = write(encodePublish(statusTopic, []byte("offline"), true))
+			_ = write(encodePacket(packetDisconnect, 0, nil))
+			return true, nil
+		case err := <-readErr:
+			return true, err
+		case <-ping.C:
+			if err := write(encodePacket(packetPingreq, 0, nil)); err != nil {
+				return true, err
+			}
+		case m := <-p.queue:
+			if err := p.publishChange(write, m); err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+func (p *Publisher) dial(u *url.URL) (net.Conn, error) {
+	host := u.Host
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if u.Scheme == "mqtts" {
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "8883")
+		}
+		return tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	}
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "1883")
+	}
+	return dialer.Dial("tcp", host)
+}
+
+// publishChange publishes a change's retained IP and its event.
+func (p *Publisher) publishChange(write func([]byte) error, m message) error {
+	if err := p.publishIP(write, m.name, m.ip); err != nil {
+		return err
+	}
+	event, err := json.Marshal(Event{
+		IAM:        m.name,
+		IP:         m.ip,
+		PreviousIP: m.previous,
+		Timestamp:  m.time.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	return write(encodePublish(p.cfg.TopicPrefix+"/events", event, false))
+}
+
+var (
+	// topicWildcards matches characters that can't appear in a topic level.
+	topicWildcards = regexp.MustCompile(`[+#/\x00]`)
+	// objectIDChars matches characters not allowed in discovery object IDs.
+	objectIDChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+)
+
+// publishIP publishes the retained IP of a name, announcing it to Home
+// Assistant first if discovery is enabled.
+func (p *Publisher) publishIP(write func([]byte) error, name, ip string) error {
+	if name == "" || topicWildcards.MatchString(name) {
+		return nil
+	}
+	stateTopic := p.cfg.TopicPrefix + "/" + name + "/ip"
+
+	if p.cfg.Discovery && !p.announced[name] && ip != "" {
+		id := "who_" + objectIDChars.ReplaceAllString(name, "_")
+		payload, err := json.Marshal(discoveryConfig{
+			Name:              name,
+			UniqueID:          id,
+			StateTopic:        stateTopic,
+			AvailabilityTopic: p.cfg.TopicPrefix + "/status",
+			Icon:              "mdi:ip-network",
+			Device:            discoveryDevice{Identifiers: []string{p.cfg.ClientID}, Name: "who"},
+		})
+		if err != nil {
+			return err
+		}
+		if err := write(encodePublish(p.cfg.DiscoveryPrefix+"/sensor/"+id+"/config", payload, true)); err != nil {
+			return err
+		}
+		p.announced[name] = true
+	}
+
+	// An empty retained message clears the topic
+	return write(encodePublish(stateTopic, []byte(ip), true))
+}
@@ -0,0 +1,307 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// brokerConn is a client connection accepted by fakeBroker.
+type brokerConn struct {
+	conn      net.Conn
+	connect   connect
+	publishes chan publish
+}
+
+// publish is a PUBLISH packet received by fakeBroker.
+type publish struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+// fakeBroker accepts MQTT connections, acknowledges CONNECT and PINGREQ and
+// passes every PUBLISH on.
+type fakeBroker struct {
+	ln    net.Listener
+	conns chan *brokerConn
+
+	mu     sync.Mutex
+	opened []net.Conn
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, conns: make(chan *brokerConn, 4)}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for _, conn := range b.opened {
+			_ = conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.opened = append(b.opened, conn)
+			b.mu.Unlock()
+			go b.serve(t, conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) url() string {
+	return "mqtt://" + b.ln.Addr().String()
+}
+
+func (b *fakeBroker) serve(t *testing.T, conn net.Conn) {
+	r := bufio.NewReader(conn)
+	p, err := readPacket(r)
+	if err != nil {
+		return
+	}
+	c, err := decodeConnect(p)
+	if err != nil {
+		t.Errorf("decoding CONNECT: %v", err)
+		_ = conn.Close()
+		return
+	}
+	if _, err := conn.Write(encodePacket(packetConnack, 0, []byte{0, 0})); err != nil {
+		return
+	}
+
+	bc := &brokerConn{conn: conn, connect: c, publishes: make(chan publish, 64)}
+	b.conns <- bc
+	defer close(bc.publishes)
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch p.kind {
+		case packetPublish:
+			topicLen := int(binary.BigEndian.Uint16(p.body))
+			bc.publishes <- publish{
+				topic:   string(p.body[2 : 2+topicLen]),
+				payload: string(p.body[2+topicLen:]),
+				retain:  p.flags&0x01 != 0,
+			}
+		case packetPingreq:
+			_, _ = conn.Write(encodePacket(packetPingresp, 0, nil))
+		case packetDisconnect:
+			return
+		}
+	}
+}
+
+// decodeConnect is the inverse of connect.encode.
+func decodeConnect(p packet) (connect, error) {
+	if p.kind != packetConnect {
+		return connect{}, errors.New("not a CONNECT packet")
+	}
+	body := p.body
+	next := func() string {
+		if len(body) < 2 {
+			return ""
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		s := string(body[2 : 2+n])
+		body = body[2+n:]
+		return s
+	}
+
+	if next() != "MQTT" || len(body) < 4 || body[0] != 4 {
+		return connect{}, errors.New("not MQTT 3.1.1")
+	}
+	flags := body[1]
+	c := connect{keepAlive: binary.BigEndian.Uint16(body[2:4])}
+	body = body[4:]
+	c.clientID = next()
+	if flags&0x04 != 0 {
+		c.willTopic = next()
+		c.willMessage = []byte(next())
+		c.willRetain = flags&0x20 != 0
+	}
+	if flags&0x80 != 0 {
+		c.username = next()
+	}
+	if flags&0x40 != 0 {
+		c.password = next()
+	}
+	return c, nil
+}
+
+func (b *fakeBroker) accept(t *testing.T) *brokerConn {
+	t.Helper()
+	select {
+	case c := <-b.conns:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher didn't connect")
+		return nil
+	}
+}
+
+// expect returns the next PUBLISH and fails unless it is to topic.
+func (c *brokerConn) expect(t *testing.T, topic string, retain bool) string {
+	t.Helper()
+	select {
+	case p, ok := <-c.publishes:
+		if !ok {
+			t.Fatalf("connection closed, want PUBLISH to %s", topic)
+		}
+		if p.topic != topic || p.retain != retain {
+			t.Fatalf("PUBLISH to %s (retain %t), want %s (retain %t)", p.topic, p.retain, topic, retain)
+		}
+		return p.payload
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no PUBLISH to %s", topic)
+		return ""
+	}
+}
+
+func newTestPublisher(t *testing.T, cfg Config, addresses map[string]string) *Publisher {
+	t.Helper()
+	p, err := NewPublisher(cfg, func() map[string]string { return addresses })
+	if err != nil {
+		t.Fatalf("NewPublisher: %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestPublisherConnect(t *testing.T) {
+	b := newFakeBroker(t)
+	newTestPublisher(t, Config{URL: b.url(), Username: "who", Password: "secret"}, map[string]string{"nas": "192.168.1.10"})
+
+	c := b.accept(t)
+	want := connect{
+		clientID:    "who",
+		username:    "who",
+		password:    "secret",
+		keepAlive:   60,
+		willTopic:   "who/status",
+		willMessage: []byte("offline"),
+		willRetain:  true,
+	}
+	got := c.connect
+	if got.clientID != want.clientID || got.username != want.username || got.password != want.password ||
+		got.keepAlive != want.keepAlive || got.willTopic != want.willTopic ||
+		string(got.willMessage) != string(want.willMessage) || got.willRetain != want.willRetain {
+		t.Errorf("CONNECT = %+v, want %+v", got, want)
+	}
+
+	if status := c.expect(t, "who/status", true); status != "online" {
+		t.Errorf("status = %q, want online", status)
+	}
+	if ip := c.expect(t, "who/nas/ip", true); ip != "192.168.1.10" {
+		t.Errorf("nas/ip = %q, want 192.168.1.10", ip)
+	}
+}
+
+func TestPublisherChange(t *testing.T) {
+	b := newFakeBroker(t)
+	p := newTestPublisher(t, Config{URL: b.url(), TopicPrefix: "home"}, nil)
+	c := b.accept(t)
+	c.expect(t, "home/status", true)
+
+	p.TriggerPublish("laptop", "10.0.0.5", "10.0.0.4")
+	if ip := c.expect(t, "home/laptop/ip", true); ip != "10.0.0.5" {
+		t.Errorf("laptop/ip = %q, want 10.0.0.5", ip)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(c.expect(t, "home/events", false)), &e); err != nil {
+		t.Fatalf("decoding event: %v", err)
+	}
+	if e.IAM != "laptop" || e.IP != "10.0.0.5" || e.PreviousIP != "10.0.0.4" {
+		t.Errorf("event = %+v", e)
+	}
+	if _, err := time.Parse(time.RFC3339, e.Timestamp); err != nil {
+		t.Errorf("event timestamp: %v", err)
+	}
+
+	// Removing a name clears its retained IP
+	p.TriggerPublish("laptop", "", "10.0.0.5")
+	if ip := c.expect(t, "home/laptop/ip", true); ip != "" {
+		t.Errorf("laptop/ip = %q after removal, want empty", ip)
+	}
+	c.expect(t, "home/events", false)
+}
+
+func TestPublisherDiscovery(t *testing.T) {
+	b := newFakeBroker(t)
+	p := newTestPublisher(t, Config{URL: b.url(), Discovery: true}, map[string]string{"my.nas": "192.168.1.10"})
+	c := b.accept(t)
+	c.expect(t, "who/status", true)
+
+	var d discoveryConfig
+	if err := json.Unmarshal([]byte(c.expect(t, "homeassistant/sensor/who_my_nas/config", true)), &d); err != nil {
+		t.Fatalf("decoding discovery config: %v", err)
+	}
+	if d.Name != "my.nas" || d.UniqueID != "who_my_nas" || d.StateTopic != "who/my.nas/ip" || d.AvailabilityTopic != "who/status" {
+		t.Errorf("discovery config = %+v", d)
+	}
+	c.expect(t, "who/my.nas/ip", true)
+
+	// Names are announced once per connection
+	p.TriggerPublish("my.nas", "192.168.1.11", "192.168.1.10")
+	c.expect(t, "who/my.nas/ip", true)
+	c.expect(t, "who/events", false)
+}
+
+func TestPublisherReconnect(t *testing.T) {
+	b := newFakeBroker(t)
+	addresses := map[string]string{"nas": "192.168.1.10"}
+	p := newTestPublisher(t, Config{URL: b.url(), Discovery: true}, addresses)
+
+	c := b.accept(t)
+	c.expect(t, "who/status", true)
+	c.expect(t, "homeassistant/sensor/who_nas/config", true)
+	c.expect(t, "who/nas/ip", true)
+
+	// Dropping the connection makes the publisher reconnect and republish
+	// its retained state, including discovery
+	_ = c.conn.Close()
+	c = b.accept(t)
+	c.expect(t, "who/status", true)
+	c.expect(t, "homeassistant/sensor/who_nas/config", true)
+	if ip := c.expect(t, "who/nas/ip", true); ip != "192.168.1.10" {
+		t.Errorf("nas/ip = %q after reconnect, want 192.168.1.10", ip)
+	}
+
+	p.TriggerPublish("nas", "192.168.1.11", "192.168.1.10")
+	c.expect(t, "who/nas/ip", true)
+	c.expect(t, "who/events", false)
+
+	// Closing marks the publisher offline before disconnecting
+	p.Close()
+	if status := c.expect(t, "who/status", true); status != "offline" {
+		t.Errorf("status = %q on close, want offline", status)
+	}
+}
+
+func TestNewPublisherKeepAlive(t *testing.T) {
+	for _, keepAlive := range []time.Duration{500 * time.Millisecond, maxKeepAlive + time.Second} {
+		_, err := NewPublisher(Config{URL: "mqtt://127.0.0.1:1883", KeepAlive: keepAlive}, nil)
+		if err == nil || !strings.Contains(err.Error(), "keep_alive") {
+			t.Errorf("NewPublisher with keep_alive %s: error = %v", keepAlive, err)
+		}
+	}
+}
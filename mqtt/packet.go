@@ -0,0 +1,163 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPingreq    = 12
+	packetPingresp   = 13
+	packetDisconnect = 14
+)
+
+// maxRemainingLength is the largest length a packet can declare.
+const maxRemainingLength = 268435455
+
+// connackErrors are the CONNACK return codes other than 0 (accepted).
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// connect holds the fields of a CONNECT packet.
+type connect struct {
+	clientID    string
+	username    string
+	password    string
+	keepAlive   uint16 // seconds
+	willTopic   string // no will if empty
+	willMessage []byte
+	willRetain  bool
+}
+
+// packet is a control packet as read from the wire.
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func (c connect) encode() []byte {
+	flags := byte(0x02) // clean session
+	if c.username != "" {
+		flags |= 0x80
+	}
+	if c.password != "" {
+		flags |= 0x40
+	}
+	if c.willTopic != "" {
+		flags |= 0x04
+		if c.willRetain {
+			flags |= 0x20
+		}
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 4 is 3.1.1
+	body = binary.BigEndian.AppendUint16(body, c.keepAlive)
+	body = appendString(body, c.clientID)
+	if c.willTopic != "" {
+		body = appendString(body, c.willTopic)
+		body = appendBytes(body, c.willMessage)
+	}
+	if c.username != "" {
+		body = appendString(body, c.username)
+	}
+	if c.password != "" {
+		body = appendString(body, c.password)
+	}
+	return encodePacket(packetConnect, 0, body)
+}
+
+// encodePublish returns a QoS 0 PUBLISH packet.
+func encodePublish(topic string, payload []byte, retain bool) []byte {
+	var flags byte
+	if retain {
+		flags = 0x01
+	}
+	body := appendString(nil, topic)
+	body = append(body, payload...)
+	return encodePacket(packetPublish, flags, body)
+}
+
+func encodePacket(kind, flags byte, body []byte) []byte {
+	b := []byte{kind<<4 | flags}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// readPacket reads one control packet.
+func readPacket(r *bufio.Reader) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	var length, multiplier int = 0, 1
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if length > maxRemainingLength {
+			return packet{}, errors.New("malformed remaining length")
+		}
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+// connackError returns the error for a CONNACK packet, or nil if the
+// connection was accepted.
+func connackError(p packet) error {
+	if p.kind != packetConnack || len(p.body) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", p.kind)
+	}
+	if code := p.body[1]; code != 0 {
+		if reason, ok := connackErrors[code]; ok {
+			return fmt.Errorf("connection refused: %s", reason)
+		}
+		return fmt.Errorf("connection refused: code %d", code)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/tracyhatemice/who/event"
+)
+
+// commandTimeout bounds how long a reload command may run.
+const commandTimeout = 30 * time.Second
+
+// Entry represents a file sink.
+type Entry struct {
+	Path     string
+	IAM      []string // names that trigger a render; empty means every name
+	Command  []string
+	template *template.Template
+	wake     chan struct{}
+}
+
+// Config holds file sink configuration from main config.
+type Config struct {
+	Path         string
+	Template     string
+	TemplateFile string
+	IAM          []string
+	Command      []string
+}
+
+// SnapshotFunc returns the current IPs of every name, alias and failover group.
+type SnapshotFunc func() map[string][]string
+
+// Data is passed to sink templates.
+type Data struct {
+	Names map[string][]string // name → current IPs
+	Time  time.Time
+}
+
+// Lookup returns the current IPs of a name, or nil if it has none.
+func (d Data) Lookup(name string) []string {
+	return d.Names[name]
+}
+
+// funcs are the helper functions available in sink templates.
+var funcs = template.FuncMap{
+	"ipv4": func(ips []string) []string { return filterIPs(ips, netip.Addr.Is4) },
+	"ipv6": func(ips []string) []string { return filterIPs(ips, netip.Addr.Is6) },
+	"join": func(sep string, s []string) string { return strings.Join(s, sep) },
+}
+
+func filterIPs(ips []string, keep func(netip.Addr) bool) []string {
+	var filtered []string
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil && keep(addr.Unmap()) {
+			filtered = append(filtered, ip)
+		}
+	}
+	return filtered
+}
+
+// Dispatcher manages file sinks and re-renders them when names change.
+type Dispatcher struct {
+	entries  []*Entry
+	watchers map[string][]*Entry // name → sinks watching it
+	snapshot SnapshotFunc
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a Dispatcher from configuration and renders every
+// sink once. Sinks watching an alias are also re-rendered when one of its
+// members changes.
+func NewDispatcher(configs []Config, aliases map[string][]string, snapshot SnapshotFunc) *Dispatcher {
+	d := &Dispatcher{
+		watchers: make(map[string][]*Entry),
+		snapshot: snapshot,
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	for _, cfg := range configs {
+		if cfg.Path == "" {
+			log.Printf("SINK: skipping entry with empty path")
+			continue
+		}
+
+		text := cfg.Template
+		if cfg.TemplateFile != "" {
+			if text != "" {
+				log.Printf("SINK: template and template_file are mutually exclusive for %s, skipping", cfg.Path)
+				continue
+			}
+			data, err := os.ReadFile(cfg.TemplateFile)
+			if err != nil {
+				log.Printf("SINK: failed to read template for %s: %v", cfg.Path, err)
+				continue
+			}
+			text = string(data)
+		}
+
+		tmpl, err := template.New(filepath.Base(cfg.Path)).Funcs(funcs).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			log.Printf("SINK: invalid template for %s: %v", cfg.Path, err)
+			continue
+		}
+
+		entry := &Entry{
+			Path:     cfg.Path,
+			IAM:      cfg.IAM,
+			Command:  cfg.Command,
+			template: tmpl,
+			wake:     make(chan struct{}, 1),
+		}
+		d.entries = append(d.entries, entry)
+		for _, name := range cfg.IAM {
+			d.watchers[name] = append(d.watchers[name], entry)
+			for _, member := range aliases[name] {
+				d.watchers[member] = append(d.watchers[member], entry)
+			}
+		}
+
+		d.wg.Add(1)
+		go d.work(entry)
+		entry.wake <- struct{}{}
+	}
+	return d
+}
+
+// Handle implements event.Subscriber.
+func (d *Dispatcher) Handle(e event.Event) {
+	d.TriggerRender(e.Name)
+}
+
+// TriggerRender re-renders every sink that watches name.
+func (d *Dispatcher) TriggerRender(name string) {
+	for _, entry := range d.entries {
+		if len(entry.IAM) == 0 || slices.Contains(d.watchers[name], entry) {
+			select {
+			case entry.wake <- struct{}{}:
+			default: // already signalled
+			}
+		}
+	}
+}
+
+// Close stops rendering and waits for running renders and commands.
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// work renders an entry each time it is woken, until the dispatcher is
+// closed. Changes arriving during a render are coalesced into one more.
+func (d *Dispatcher) work(e *Entry) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-e.wake:
+		}
+		d.render(e)
+	}
+}
+
+// render writes the entry's template to its path if the output changed,
+// then runs the reload command.
+func (d *Dispatcher) render(e *Entry) {
+	var buf bytes.Buffer
+	if err := e.template.Execute(&buf, Data{Names: d.snapshot(), Time: time.Now().UTC()}); err != nil {
+		log.Printf("SINK: failed to render %s: %v", e.Path, err)
+		return
+	}
+
+	if current, err := os.ReadFile(e.Path); err == nil && bytes.Equal(current, buf.Bytes()) {
+		return
+	}
+	if err := writeFile(e.Path, buf.Bytes()); err != nil {
+		log.Printf("SINK: failed to write %s: %v", e.Path, err)
+		return
+	}
+	log.Printf("SINK: wrote %s", e.Path)
+
+	if len(e.Command) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(d.ctx, commandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...).CombinedOutput()
+	if output := strings.TrimSpace(string(out)); output != "" {
+		log.Printf("SINK: %s: %s", e.Command[0], output)
+	}
+	if err != nil {
+		log.Printf("SINK: reload command for %s failed: %v", e.Path, err)
+	}
+}
+
+// writeFile atomically replaces path with data, keeping the mode of an
+// existing file.
+func writeFile(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,31 +1,171 @@
 package main
 
-import "sync"
+import (
+	"slices"
+	"sync"
+	"time"
+)
 
 // Store provides thread-safe name-to-IP storage.
 type Store struct {
-	mu   sync.RWMutex
-	data map[string]string
+	mu       sync.RWMutex
+	data     map[string]storeEntry
+	version  uint64
+	versions map[string]uint64 // name → version of its last change, kept after removal
+	watchers map[string][]*watcher
+}
+
+// watcher is notified once when any of the names it watches changes.
+type watcher struct {
+	ch   chan struct{}
+	once sync.Once
+}
+
+// storeEntry is a stored IP and when its name last checked in.
+type storeEntry struct {
+	ip   string
+	seen time.Time
+}
+
+// expiredName is the last IP of a name removed by Expire and the version
+// of its removal.
+type expiredName struct {
+	ip      string
+	version uint64
 }
 
 // NewStore creates a new thread-safe store.
 func NewStore() *Store {
-	return &Store{data: make(map[string]string)}
+	return &Store{
+		data:     make(map[string]storeEntry),
+		versions: make(map[string]uint64),
+		watchers: make(map[string][]*watcher),
+	}
+}
+
+// Set stores a name-IP mapping and returns the previous IP (empty if the
+// name wasn't stored) and the version of the change, which orders changes
+// of the same name. The version is zero if the IP didn't change.
+func (s *Store) Set(name, ip string) (previous string, version uint64) {
+	return s.Restore(name, ip, time.Now())
 }
 
-// Set stores a name-IP mapping and returns true if the IP changed.
-func (s *Store) Set(name, ip string) (changed bool) {
+// Restore stores a name-IP mapping that last checked in at seen, e.g. from
+// an export, and returns the previous IP and the version of the change.
+// A name that checked in after seen is left unchanged, with version zero.
+func (s *Store) Restore(name, ip string, seen time.Time) (previous string, version uint64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	old, exists := s.data[name]
-	s.data[name] = ip
-	return !exists || old != ip
+	if old.seen.After(seen) {
+		return old.ip, 0
+	}
+	s.data[name] = storeEntry{ip: ip, seen: seen}
+	if !exists || old.ip != ip {
+		version = s.bump(name)
+	}
+	return old.ip, version
 }
 
 // Get retrieves an IP by name. Returns empty string and false if not found.
 func (s *Store) Get(name string) (string, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	ip, ok := s.data[name]
-	return ip, ok
+	e, ok := s.data[name]
+	return e.ip, ok
+}
+
+// Seen retrieves an IP by name along with when the name last checked in.
+func (s *Store) Seen(name string) (string, time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.data[name]
+	return e.ip, e.seen, ok
+}
+
+// All returns a snapshot of every stored name-IP mapping.
+func (s *Store) All() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make(map[string]string, len(s.data))
+	for name, e := range s.data {
+		all[name] = e.ip
+	}
+	return all
+}
+
+// Delete removes a name and returns its IP and the version of the change,
+// or zero if it wasn't stored.
+func (s *Store) Delete(name string) (ip string, version uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.data[name]
+	if ok {
+		delete(s.data, name)
+		version = s.bump(name)
+	}
+	return e.ip, version
+}
+
+// Expire removes every name except those in keep that hasn't checked in
+// for maxAge and returns the removed names.
+func (s *Store) Expire(maxAge time.Duration, keep map[string]bool) map[string]expiredName {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := make(map[string]expiredName)
+	cutoff := time.Now().Add(-maxAge)
+	for name, e := range s.data {
+		if e.seen.Before(cutoff) && !keep[name] {
+			delete(s.data, name)
+			expired[name] = expiredName{ip: e.ip, version: s.bump(name)}
+		}
+	}
+	return expired
+}
+
+// Notify records a change of name that isn't stored itself, such as a
+// failover group switching, wakes its watchers and returns the version of
+// the change.
+func (s *Store) Notify(name string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bump(name)
+}
+
+// Watch returns the latest version of the given names and a channel that
+// is closed when any of them changes. stop must be called once the channel
+// is no longer needed.
+func (s *Store) Watch(names ...string) (version uint64, changed <-chan struct{}, stop func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := &watcher{ch: make(chan struct{})}
+	for _, name := range names {
+		version = max(version, s.versions[name])
+		s.watchers[name] = append(s.watchers[name], w)
+	}
+
+	stop = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, name := range names {
+			s.watchers[name] = slices.DeleteFunc(s.watchers[name], func(other *watcher) bool { return other == w })
+			if len(s.watchers[name]) == 0 {
+				delete(s.watchers, name)
+			}
+		}
+	}
+	return version, w.ch, stop
+}
+
+// bump assigns the next version to name and wakes its watchers. The caller
+// must hold s.mu.
+func (s *Store) bump(name string) uint64 {
+	s.version++
+	s.versions[name] = s.version
+	for _, w := range s.watchers[name] {
+		w.once.Do(func() { close(w.ch) })
+	}
+	delete(s.watchers, name)
+	return s.version
 }
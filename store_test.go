@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStoreRestore(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		stored      time.Time // zero if the name isn't stored
+		ip          string
+		seen        time.Time
+		wantIP      string
+		wantSeen    time.Time
+		wantVersion bool
+	}{
+		{"new name", time.Time{}, "10.0.0.2", now, "10.0.0.2", now, true},
+		{"older stored", now.Add(-time.Hour), "10.0.0.2", now, "10.0.0.2", now, true},
+		{"older stored, same IP", now.Add(-time.Hour), "10.0.0.1", now, "10.0.0.1", now, false},
+		{"same time", now, "10.0.0.2", now, "10.0.0.2", now, true},
+		{"newer stored", now, "10.0.0.2", now.Add(-time.Hour), "10.0.0.1", now, false},
+		{"newer stored, same IP", now, "10.0.0.1", now.Add(-time.Hour), "10.0.0.1", now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore()
+			if !tt.stored.IsZero() {
+				s.Restore("nas", "10.0.0.1", tt.stored)
+			}
+			previous, version := s.Restore("nas", tt.ip, tt.seen)
+			if (version != 0) != tt.wantVersion {
+				t.Errorf("version = %d, want a change: %t", version, tt.wantVersion)
+			}
+			wantPrevious := ""
+			if !tt.stored.IsZero() {
+				wantPrevious = "10.0.0.1"
+			}
+			if previous != wantPrevious {
+				t.Errorf("previous = %q, want %q", previous, wantPrevious)
+			}
+			ip, seen, _ := s.Seen("nas")
+			if ip != tt.wantIP || !seen.Equal(tt.wantSeen) {
+				t.Errorf("stored %s seen %s, want %s seen %s", ip, seen, tt.wantIP, tt.wantSeen)
+			}
+		})
+	}
+}
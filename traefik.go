@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// traefikConfig is the subset of Traefik's dynamic configuration served by
+// /traefik/dynamic.
+type traefikConfig struct {
+	HTTP traefikHTTP `json:"http"`
+}
+
+type traefikHTTP struct {
+	Middlewares map[string]traefikMiddleware `json:"middlewares"`
+}
+
+type traefikMiddleware struct {
+	IPAllowList traefikIPAllowList `json:"ipAllowList"`
+}
+
+type traefikIPAllowList struct {
+	SourceRange []string `json:"sourceRange"`
+}
+
+// traefikHandler serves Traefik dynamic configuration for the HTTP provider,
+// with one ipAllowList middleware per name, alias and failover group.
+func (s *Server) traefikHandler(w http.ResponseWriter, r *http.Request) {
+	widen, err := parseWidening(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg := traefikConfig{HTTP: traefikHTTP{Middlewares: make(map[string]traefikMiddleware)}}
+	for _, name := range s.names() {
+		var ranges []string
+		for _, ip := range s.resolve(name) {
+			if prefix, ok := widen.prefix(ip); ok {
+				ranges = append(ranges, prefix.String())
+			}
+		}
+		// Traefik rejects an empty sourceRange, so names without an IP are
+		// left out and routers using them stay disabled.
+		if len(ranges) == 0 {
+			continue
+		}
+		slices.Sort(ranges)
+		cfg.HTTP.Middlewares[name] = traefikMiddleware{
+			IPAllowList: traefikIPAllowList{SourceRange: slices.Compact(ranges)},
+		}
+	}
+
+	body, err := json.Marshal(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(body, '\n'))
+}
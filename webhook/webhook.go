@@ -6,6 +6,8 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/tracyhatemice/who/event"
 )
 
 // Entry represents a webhook configuration.
@@ -34,6 +36,7 @@ type Dispatcher struct {
 type Payload struct {
 	IAM       string `json:"iam"`
 	IP        string `json:"ip"`
+	Event     string `json:"event,omitempty"` // "offline" if a failover group has no member left
 	Timestamp string `json:"timestamp"`
 }
 
@@ -68,8 +71,23 @@ func NewDispatcher(configs []Config) *Dispatcher {
 	return d
 }
 
+// Handle implements event.Subscriber. Removed names don't notify, but a
+// failover group going offline does.
+func (d *Dispatcher) Handle(e event.Event) {
+	switch {
+	case e.New != "":
+		d.TriggerWebhook(e.Name, e.New)
+	case e.Kind == event.Offline:
+		d.trigger(e.Name, "", string(event.Offline))
+	}
+}
+
 // TriggerWebhook checks if the name has webhook configs and sends notifications.
 func (d *Dispatcher) TriggerWebhook(name, ip string) {
+	d.trigger(name, ip, "")
+}
+
+func (d *Dispatcher) trigger(name, ip, kind string) {
 	entries, ok := d.entries[name]
 	if !ok {
 		return
@@ -77,15 +95,16 @@ func (d *Dispatcher) TriggerWebhook(name, ip string) {
 
 	for _, entry := range entries {
 		// Async send
-		go d.send(entry, name, ip)
+		go d.send(entry, name, ip, kind)
 	}
 }
 
 // send sends a webhook notification.
-func (d *Dispatcher) send(entry *Entry, name, ip string) {
+func (d *Dispatcher) send(entry *Entry, name, ip, kind string) {
 	payload := Payload{
 		IAM:       name,
 		IP:        ip,
+		Event:     kind,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 	}
 